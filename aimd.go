@@ -0,0 +1,225 @@
+package bucket
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gocloud.dev/gcerrors"
+)
+
+// DEFAULT_MIN_GET_CONCURRENCY is the default lower bound for the number of concurrent object read and attribute requests when adaptive concurrency is enabled.
+const DEFAULT_MIN_GET_CONCURRENCY int = 1
+
+// DEFAULT_MAX_GET_CONCURRENCY is the default upper bound for the number of concurrent object read and attribute requests when adaptive concurrency is enabled.
+const DEFAULT_MAX_GET_CONCURRENCY int = 32
+
+// DEFAULT_MIN_LIST_CONCURRENCY is the default lower bound for the number of concurrent listing requests when adaptive concurrency is enabled.
+const DEFAULT_MIN_LIST_CONCURRENCY int = 1
+
+// DEFAULT_MAX_LIST_CONCURRENCY is the default upper bound for the number of concurrent listing requests when adaptive concurrency is enabled.
+const DEFAULT_MAX_LIST_CONCURRENCY int = 8
+
+// DEFAULT_ADAPTIVE_LATENCY_TARGET is the default request latency above which adaptive concurrency is decreased.
+const DEFAULT_ADAPTIVE_LATENCY_TARGET time.Duration = 1 * time.Second
+
+// AIMD_DECREASE_FACTOR is the factor by which the concurrency limit is multiplied when requests are throttled or slow.
+const AIMD_DECREASE_FACTOR float64 = 0.5
+
+// adaptiveLimiter limits the number of concurrent requests for a single kind of bucket operation, shared across goroutines,
+// adjusting the limit using additive-increase/multiplicative-decrease (AIMD). The limit is increased by one after a full
+// limit's worth of healthy requests and multiplied by `AIMD_DECREASE_FACTOR` when a request is throttled (fails with a
+// ResourceExhausted error) or is slower than the latency target. The limit always stays between 'min' and 'max'.
+type adaptiveLimiter struct {
+	// op is the name of the operation being limited, used for logging.
+	op string
+	// mu guards all the fields below.
+	mu *sync.Mutex
+	// min is the lower bound for 'limit'.
+	min int
+	// max is the upper bound for 'limit'.
+	max int
+	// limit is the current maximum number of concurrent requests.
+	limit int
+	// in_flight is the number of requests currently in progress.
+	in_flight int
+	// successes is the number of healthy requests since the limit was last changed.
+	successes int
+	// latency_target is the request latency above which the limit is decreased.
+	latency_target time.Duration
+	// last_decrease is the time the limit was last decreased. Requests started before then do not decrease the limit
+	// again since they were issued under the previous (higher) limit.
+	last_decrease time.Time
+	// released is closed, and replaced, whenever a slot is released or the limit is increased to wake up waiting callers.
+	released chan bool
+	// logger is the `slog.Logger` instance used to log changes to the limit.
+	logger *slog.Logger
+}
+
+// newAdaptiveLimiter returns a new `adaptiveLimiter` instance for 'op' whose limit starts at, and never falls below, 'min_limit' and never exceeds 'max_limit'.
+func newAdaptiveLimiter(op string, min_limit int, max_limit int, latency_target time.Duration) *adaptiveLimiter {
+
+	l := &adaptiveLimiter{
+		op:             op,
+		mu:             new(sync.Mutex),
+		min:            min_limit,
+		max:            max_limit,
+		limit:          min_limit,
+		latency_target: latency_target,
+		released:       make(chan bool),
+		logger:         slog.Default(),
+	}
+
+	return l
+}
+
+// Acquire blocks until the number of requests in progress is below the current limit or 'ctx' is cancelled, in which
+// case the context's error is returned. Callers must call `Release` when the request (or the work it was acquired for)
+// finishes.
+func (l *adaptiveLimiter) Acquire(ctx context.Context) error {
+
+	for {
+
+		l.mu.Lock()
+
+		if l.in_flight < l.limit {
+			l.in_flight += 1
+			l.mu.Unlock()
+			return nil
+		}
+
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+			// pass
+		}
+	}
+}
+
+// Release frees a slot acquired with `Acquire` and wakes up any callers waiting for one.
+func (l *adaptiveLimiter) Release() {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.in_flight -= 1
+	l.wake()
+}
+
+// Observe records the outcome of a request which started at 'start' and failed with 'err' (or nil), adjusting the
+// limit accordingly.
+func (l *adaptiveLimiter) Observe(start time.Time, err error) {
+
+	latency := time.Since(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	throttled := err != nil && gcerrors.Code(err) == gcerrors.ResourceExhausted
+	slow := latency > l.latency_target
+
+	switch {
+	case throttled || slow:
+
+		if start.Before(l.last_decrease) {
+			return
+		}
+
+		reason := "latency"
+
+		if throttled {
+			reason = "throttled"
+		}
+
+		limit := max(l.min, int(float64(l.limit)*AIMD_DECREASE_FACTOR))
+		l.last_decrease = time.Now()
+		l.adjust(limit, reason, latency)
+
+	case err != nil:
+		// Other errors say nothing about the health of the bucket
+	default:
+
+		l.successes += 1
+
+		if l.successes >= l.limit {
+			l.adjust(min(l.max, l.limit+1), "healthy", latency)
+		}
+	}
+}
+
+// Limit returns the current maximum number of concurrent requests.
+func (l *adaptiveLimiter) Limit() int {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// adjust sets the limit to 'limit', logging the change if there is one. The caller must hold 'mu'.
+func (l *adaptiveLimiter) adjust(limit int, reason string, latency time.Duration) {
+
+	l.successes = 0
+
+	if limit == l.limit {
+		return
+	}
+
+	l.logger.Info("Adjust bucket concurrency", "op", l.op, "from", l.limit, "to", limit, "reason", reason, "latency", latency)
+
+	if limit > l.limit {
+		l.wake()
+	}
+
+	l.limit = limit
+}
+
+// wake wakes up any callers waiting in `Acquire`. The caller must hold 'mu'.
+func (l *adaptiveLimiter) wake() {
+	close(l.released)
+	l.released = make(chan bool)
+}
+
+// heldSlotKey is the `context.Context` key for the `adaptiveLimiter` whose slot is held by the work being done with that context.
+type heldSlotKey struct{}
+
+// withHeldSlot returns a new `context.Context` recording that a slot in 'l' has already been acquired for the work done
+// with it, so that `withConcurrency` does not acquire another one for each request.
+func withHeldSlot(ctx context.Context, l *adaptiveLimiter) context.Context {
+	return context.WithValue(ctx, heldSlotKey{}, l)
+}
+
+// withConcurrency invokes 'fn' once 'l' allows it, reporting the outcome to 'l' when it returns. If 'l' is nil 'fn' is
+// invoked immediately. If a slot in 'l' is already held for 'ctx' (see `withHeldSlot`) 'fn' is invoked immediately and
+// its outcome is reported to 'l' but the slot is not released.
+func withConcurrency[T any](ctx context.Context, l *adaptiveLimiter, fn func() (T, error)) (T, error) {
+
+	if l == nil {
+		return fn()
+	}
+
+	held, _ := ctx.Value(heldSlotKey{}).(*adaptiveLimiter)
+
+	if held != l {
+
+		err := l.Acquire(ctx)
+
+		if err != nil {
+			var v T
+			return v, err
+		}
+
+		defer l.Release()
+	}
+
+	start := time.Now()
+	v, err := fn()
+
+	l.Observe(start, err)
+	return v, err
+}
@@ -0,0 +1,328 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+
+	ctx := context.Background()
+
+	l := newAdaptiveLimiter("test", 1, 3, time.Second)
+
+	ok_fn := func() (bool, error) {
+		return true, nil
+	}
+
+	// 1 success at limit 1, 2 at limit 2, then capped at 3
+
+	for i := 0; i < 10; i++ {
+
+		_, err := withConcurrency(ctx, l, ok_fn)
+
+		if err != nil {
+			t.Fatalf("Unexpected error, %v", err)
+		}
+	}
+
+	if l.Limit() != 3 {
+		t.Fatalf("Expected limit of 3, but got %d", l.Limit())
+	}
+
+	l.latency_target = time.Nanosecond
+
+	slow_fn := func() (bool, error) {
+		time.Sleep(time.Millisecond)
+		return true, nil
+	}
+
+	_, err := withConcurrency(ctx, l, slow_fn)
+
+	if err != nil {
+		t.Fatalf("Unexpected error, %v", err)
+	}
+
+	if l.Limit() != 1 {
+		t.Fatalf("Expected limit of 1 after slow request, but got %d", l.Limit())
+	}
+
+	_, err = withConcurrency(ctx, l, slow_fn)
+
+	if err != nil {
+		t.Fatalf("Unexpected error, %v", err)
+	}
+
+	if l.Limit() != 1 {
+		t.Fatalf("Expected limit to stay at the lower bound, but got %d", l.Limit())
+	}
+}
+
+func TestAdaptiveLimiterContext(t *testing.T) {
+
+	l := newAdaptiveLimiter("test", 1, 1, time.Second)
+
+	err := l.Acquire(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to acquire limiter, %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = l.Acquire(ctx)
+
+	if err == nil {
+		t.Fatalf("Expected acquire to fail when limit is reached and context is cancelled")
+	}
+
+	l.Release()
+
+	err = l.Acquire(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to acquire limiter after release, %v", err)
+	}
+}
+
+func TestBucketIteratorAdaptiveConcurrency(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// iterate_once returns the paths of the records yielded by the iterator for 'iter_uri', in order, and the iterator
+
+	iterate_once := func(iter_uri string) ([]string, *BucketIterator) {
+
+		iter, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator, %v", err)
+		}
+
+		defer iter.Close()
+
+		paths := make([]string, 0)
+
+		for rec, err := range iter.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket, %v", err)
+			}
+
+			rec.Body.Close()
+			paths = append(paths, rec.Path)
+		}
+
+		return paths, iter.(*BucketIterator)
+	}
+
+	paths, it := iterate_once(fmt.Sprintf("bucket-file://%s?adaptive_concurrency=true&max_get_concurrency=4", abs_path))
+
+	if len(paths) != 37 {
+		t.Fatalf("Expected 37 records, but counted %d", len(paths))
+	}
+
+	// Objects are fetched, and prefixes listed, concurrently but records are still yielded in listing order
+
+	expected, _ := iterate_once(fmt.Sprintf("bucket-file://%s", abs_path))
+
+	if !slices.Equal(paths, expected) {
+		t.Fatalf("Expected records to be yielded in listing order, %v", paths)
+	}
+
+	if it.get_concurrency.Limit() != 4 {
+		t.Fatalf("Expected get concurrency to grow to 4, but got %d", it.get_concurrency.Limit())
+	}
+
+	for _, q := range []string{"min_get_concurrency=0", "min_list_concurrency=4&max_list_concurrency=2"} {
+
+		_, err := NewBucketIterator(ctx, fmt.Sprintf("bucket-file://%s?adaptive_concurrency=true&%s", abs_path, q))
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", q)
+		}
+	}
+}
+
+func TestBucketIteratorWorkerPool(t *testing.T) {
+
+	ctx := context.Background()
+
+	objects := make(map[string]string)
+	keys := make([]string, 0)
+
+	for i := 0; i < 4; i++ {
+
+		for j := 0; j < 12; j++ {
+			k := fmt.Sprintf("%02d/%03d.geojson", i, j)
+			objects[k] = `{"type": "Feature"}`
+			keys = append(keys, k)
+		}
+	}
+
+	fb := newFakeBucket(objects)
+	fb.latency = 2 * time.Millisecond
+
+	opts := DefaultBucketIteratorOptions()
+	opts.AdaptiveConcurrency = true
+	opts.MaxGetConcurrency = 8
+
+	// Workers fetch objects ahead of the caller so, when requests are throttled below, up to 8 of them may be
+	// retrying at once until enough have given up their slots
+
+	opts.MaxRetries = 20
+	opts.RetryInitialDelay = 1 * time.Millisecond
+	opts.RetryMaxDelay = 10 * time.Millisecond
+
+	iter, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	it := iter.(*BucketIterator)
+
+	iterate_once := func() []string {
+
+		paths := make([]string, 0)
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket, %v", err)
+			}
+
+			// Hold on to the body for a while so that workers fetch objects ahead of the caller
+
+			time.Sleep(1 * time.Millisecond)
+
+			rec.Body.Close()
+			paths = append(paths, rec.Path)
+		}
+
+		return paths
+	}
+
+	// The limit grows while requests succeed
+
+	paths := iterate_once()
+
+	if !slices.Equal(paths, keys) {
+		t.Fatalf("Expected records to be yielded in listing order, %v", paths)
+	}
+
+	if it.get_concurrency.Limit() != 8 {
+		t.Fatalf("Expected get concurrency to grow to 8, but got %d", it.get_concurrency.Limit())
+	}
+
+	max_in_flight := fb.MaxInFlight()
+
+	if max_in_flight < 2 || max_in_flight > 8 {
+		t.Fatalf("Expected between 2 and 8 objects to be open at once, but counted %d", max_in_flight)
+	}
+
+	// One listing for the top-level prefixes and one for each of them
+
+	list_calls, _ := fb.Calls()
+
+	if list_calls != 5 {
+		t.Fatalf("Expected 5 listing requests, but counted %d", list_calls)
+	}
+
+	// The limit shrinks when requests are throttled
+
+	fb.get_error = func(requests int) error {
+
+		if requests > 2 {
+			return &fakeError{code: gcerrors.ResourceExhausted}
+		}
+
+		return nil
+	}
+
+	paths = iterate_once()
+
+	if !slices.Equal(paths, keys) {
+		t.Fatalf("Expected records to be yielded in listing order, %v", paths)
+	}
+
+	if it.get_concurrency.Limit() >= 8 {
+		t.Fatalf("Expected get concurrency to shrink after requests were throttled, but got %d", it.get_concurrency.Limit())
+	}
+}
+
+func TestBucketIteratorWorkerPoolUnclosedBodies(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objects := make(map[string]string)
+
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("%03d.geojson", i)
+		objects[k] = fmt.Sprintf(`{"type": "Feature", "id": %d}`, i)
+	}
+
+	fb := newFakeBucket(objects)
+
+	opts := DefaultBucketIteratorOptions()
+	opts.AdaptiveConcurrency = true
+	opts.MinGetConcurrency = 1
+	opts.MaxGetConcurrency = 1
+
+	it, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	// Record bodies are never closed but, with a limit of 1, iteration must still run to completion
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		body, err := io.ReadAll(rec.Body)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", rec.Path, err)
+		}
+
+		if string(body) != objects[rec.Path] {
+			t.Fatalf("Unexpected body for %s, %s", rec.Path, string(body))
+		}
+
+		count += 1
+	}
+
+	if count != len(objects) {
+		t.Fatalf("Expected %d records, but counted %d", len(objects), count)
+	}
+
+	if fb.MaxInFlight() != 1 {
+		t.Fatalf("Expected 1 object to be open at once, but counted %d", fb.MaxInFlight())
+	}
+}
@@ -0,0 +1,130 @@
+// Package count provides a command line application to count records using a `BucketIterator` instance. It extends the
+// `whosonfirst/go-whosonfirst-iterate/v3/app/count` package with bucket-specific flags.
+package count
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3"
+	iterate_count "github.com/whosonfirst/go-whosonfirst-iterate/v3/app/count"
+)
+
+// Run will execute a command line application to count records with a `BucketIterator` instance using a default flagset.
+func Run(ctx context.Context) error {
+	fs := DefaultFlagSet()
+	return RunWithFlagSet(ctx, fs)
+}
+
+// RunWithFlagSet will execute a command line application to count records with a `BucketIterator` instance using 'fs'.
+// If none of the bucket-specific flags are set it defers to the `whosonfirst/go-whosonfirst-iterate/v3/app/count` package. Otherwise an error is
+// returned if the iterator URI is not a bucket iterator URI.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet) error {
+
+	flagset.Parse(fs)
+
+	iterator_uri := fs.Lookup("iterator-uri").Value.String()
+
+	verbose, err := strconv.ParseBool(fs.Lookup("verbose").Value.String())
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse -verbose flag, %w", err)
+	}
+
+	if !dry_run && !progress && !prescan {
+		return iterate_count.RunWithFlagSet(ctx, fs)
+	}
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+		slog.Debug("Verbose logging enabled")
+	}
+
+	paths := fs.Args()
+
+	// The bucket-specific flags only make sense for bucket iterators so rather than handing an iterator URI for some
+	// other scheme to `bucket.NewBucketIterator`, where it would fail in confusing ways, it is rejected up front.
+
+	u, err := url.Parse(iterator_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse iterator URI, %w", err)
+	}
+
+	if u.Scheme != bucket.CATCHALL_SCHEME && !strings.HasPrefix(u.Scheme, bucket.PREFIX) {
+		return fmt.Errorf("The -dry-run, -progress and -prescan flags require a bucket iterator URI (a '%s://' or '%s{SCHEME}://' URI) but the iterator URI scheme is '%s'", bucket.CATCHALL_SCHEME, bucket.PREFIX, u.Scheme)
+	}
+
+	if dry_run {
+
+		q := u.Query()
+		q.Set("dry_run", "true")
+
+		u.RawQuery = q.Encode()
+		iterator_uri = u.String()
+	}
+
+	bucket_it, err := bucket.NewBucketIterator(ctx, iterator_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create bucket iterator, %w", err)
+	}
+
+	b_it, ok := bucket_it.(*bucket.BucketIterator)
+
+	if !ok {
+		return fmt.Errorf("Unexpected iterator type %T", bucket_it)
+	}
+
+	// The bucket iterator is wrapped by the whosonfirst/go-whosonfirst-iterate/v3 concurrent iterator so
+	// the `_max_procs`, `_retry`, `_include`, `_exclude` and `_dedupe` parameters apply.
+
+	iter := bucket_it
+	defer iter.Close()
+
+	var totals *bucket.ScanTotals
+
+	if prescan {
+
+		t, err := b_it.Scan(ctx, paths...)
+
+		if err != nil {
+			return fmt.Errorf("Failed to scan URIs, %w", err)
+		}
+
+		slog.Info("Scanned URIs", "keys", t.Keys, "bytes", t.Bytes)
+		totals = t
+	}
+
+	if progress {
+		reporter := bucket.NewProgressReporter(b_it, totals, time.Duration(progress_interval)*time.Second, bucket.NewProgressWriter(os.Stderr))
+		reporter.Start(ctx)
+		defer reporter.Stop(ctx)
+	}
+
+	count := int64(0)
+
+	t1 := time.Now()
+
+	for rec, err := range iter.Iterate(ctx, paths...) {
+
+		if err != nil {
+			return err
+		}
+
+		rec.Body.Close()
+		atomic.AddInt64(&count, 1)
+	}
+
+	slog.Info("Counted records", "count", count, "time", time.Since(t1))
+	return nil
+}
@@ -0,0 +1,38 @@
+package count
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	iterate_count "github.com/whosonfirst/go-whosonfirst-iterate/v3/app/count"
+)
+
+var progress bool
+var progress_interval int
+var prescan bool
+
+var dry_run bool
+
+// DefaultFlagSet returns a default `flag.FlagSet` for executing a command line application to count records with a
+// `BucketIterator` instance. It extends the flagset defined by the `whosonfirst/go-whosonfirst-iterate/v3/app/count`
+// package with bucket-specific flags.
+func DefaultFlagSet() *flag.FlagSet {
+
+	fs := iterate_count.DefaultFlagSet()
+
+	fs.BoolVar(&dry_run, "dry-run", false, "Count the objects that would be processed, after applying the '_include' and '_exclude' path filters and any 'include' or 'exclude' filters (which match the listing metadata rather than the object), without opening any of them. This is the same as adding '?dry_run=true' to the iterator URI.")
+
+	fs.BoolVar(&progress, "progress", false, "Periodically report progress (keys, records, bytes, rates and ETA) to STDERR.")
+	fs.IntVar(&progress_interval, "progress-interval", 5, "The number of seconds between progress reports.")
+	fs.BoolVar(&prescan, "prescan", false, "Scan the bucket listing, before iterating, to count the total number of keys and bytes to process. Progress reports will include totals and an ETA.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Count records in one or more URIs using a bucket iterator.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] uri(N) uri(N)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
@@ -0,0 +1,203 @@
+// Package emit provides a command line application to emit records using a `BucketIterator` instance as structured data.
+// It extends the `whosonfirst/go-whosonfirst-iterate/v3/app/emit` package with bucket-specific flags.
+package emit
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	iterate_emit "github.com/whosonfirst/go-whosonfirst-iterate/v3/app/emit"
+)
+
+// Run will execute a command line application for emitting records with a `BucketIterator` instance using a default flagset.
+func Run(ctx context.Context) error {
+	fs := DefaultFlagSet()
+	return RunWithFlagSet(ctx, fs)
+}
+
+// RunWithFlagSet will execute a command line application for emitting records with a `BucketIterator` instance using 'fs'.
+// If none of the bucket-specific flags are set it defers to the `whosonfirst/go-whosonfirst-iterate/v3/app/emit` package. Otherwise an error is
+// returned if the iterator URI is not a bucket iterator URI.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet) error {
+
+	flagset.Parse(fs)
+
+	iterator_uri := fs.Lookup("iterator-uri").Value.String()
+
+	if !progress && !prescan {
+		return iterate_emit.RunWithFlagSet(ctx, fs)
+	}
+
+	bools := map[string]bool{}
+
+	for _, k := range []string{"verbose", "json", "geojson", "stdout", "null"} {
+
+		v, err := strconv.ParseBool(fs.Lookup(k).Value.String())
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse -%s flag, %w", k, err)
+		}
+
+		bools[k] = v
+	}
+
+	if bools["verbose"] {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+		slog.Debug("Verbose logging enabled")
+	}
+
+	writers := make([]io.Writer, 0)
+
+	if bools["stdout"] {
+		writers = append(writers, os.Stdout)
+	}
+
+	if bools["null"] {
+		writers = append(writers, io.Discard)
+	}
+
+	wr := io.MultiWriter(writers...)
+
+	uris := fs.Args()
+
+	// The bucket-specific flags only make sense for bucket iterators so rather than handing an iterator URI for some
+	// other scheme to `bucket.NewBucketIterator`, where it would fail in confusing ways, it is rejected up front.
+
+	u, err := url.Parse(iterator_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse iterator URI, %w", err)
+	}
+
+	if u.Scheme != bucket.CATCHALL_SCHEME && !strings.HasPrefix(u.Scheme, bucket.PREFIX) {
+		return fmt.Errorf("The -progress and -prescan flags require a bucket iterator URI (a '%s://' or '%s{SCHEME}://' URI) but the iterator URI scheme is '%s'", bucket.CATCHALL_SCHEME, bucket.PREFIX, u.Scheme)
+	}
+
+	bucket_it, err := bucket.NewBucketIterator(ctx, iterator_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create bucket iterator, %w", err)
+	}
+
+	b_it, ok := bucket_it.(*bucket.BucketIterator)
+
+	if !ok {
+		return fmt.Errorf("Unexpected iterator type %T", bucket_it)
+	}
+
+	// The bucket iterator is wrapped by the whosonfirst/go-whosonfirst-iterate/v3 concurrent iterator so
+	// the `_max_procs`, `_retry`, `_include`, `_exclude` and `_dedupe` parameters apply.
+
+	iter := bucket_it
+	defer iter.Close()
+
+	var totals *bucket.ScanTotals
+
+	if prescan {
+
+		t, err := b_it.Scan(ctx, uris...)
+
+		if err != nil {
+			return fmt.Errorf("Failed to scan URIs, %w", err)
+		}
+
+		slog.Info("Scanned URIs", "keys", t.Keys, "bytes", t.Bytes)
+		totals = t
+	}
+
+	if progress {
+		reporter := bucket.NewProgressReporter(b_it, totals, time.Duration(progress_interval)*time.Second, bucket.NewProgressWriter(os.Stderr))
+		reporter.Start(ctx)
+		defer reporter.Stop(ctx)
+	}
+
+	err = emit(ctx, wr, iter, bools["json"], bools["geojson"], uris...)
+
+	if err != nil {
+		return fmt.Errorf("Failed to emit records, %w", err)
+	}
+
+	return nil
+}
+
+// emit writes the body of every record emitted by 'iter' for 'uris' to 'wr', optionally as a JSON array or a GeoJSON FeatureCollection,
+// closing each body once it has been written.
+func emit(ctx context.Context, wr io.Writer, iter iterate.Iterator, as_json bool, as_geojson bool, uris ...string) error {
+
+	if as_geojson {
+
+		_, err := wr.Write([]byte(`{"type":"FeatureCollection", "features":`))
+
+		if err != nil {
+			return fmt.Errorf("Failed to write GeoJSON header, %w", err)
+		}
+	}
+
+	if as_geojson || as_json {
+
+		_, err := wr.Write([]byte(`[`))
+
+		if err != nil {
+			return fmt.Errorf("Failed to write JSON array header, %w", err)
+		}
+	}
+
+	count := 0
+
+	for rec, err := range iter.Iterate(ctx, uris...) {
+
+		if err != nil {
+			return err
+		}
+
+		count += 1
+
+		if (as_geojson || as_json) && count > 1 {
+
+			_, err := wr.Write([]byte(`,`))
+
+			if err != nil {
+				rec.Body.Close()
+				return fmt.Errorf("Failed to write JSON array separator, %w", err)
+			}
+		}
+
+		_, err = io.Copy(wr, rec.Body)
+		rec.Body.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to copy data from %s, %w", rec.Path, err)
+		}
+	}
+
+	if as_geojson || as_json {
+
+		_, err := wr.Write([]byte(`]`))
+
+		if err != nil {
+			return fmt.Errorf("Failed to close JSON array, %w", err)
+		}
+	}
+
+	if as_geojson {
+
+		_, err := wr.Write([]byte(`}`))
+
+		if err != nil {
+			return fmt.Errorf("Failed to close GeoJSON FeatureCollection, %w", err)
+		}
+	}
+
+	return nil
+}
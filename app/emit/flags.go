@@ -0,0 +1,34 @@
+package emit
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	iterate_emit "github.com/whosonfirst/go-whosonfirst-iterate/v3/app/emit"
+)
+
+var progress bool
+var progress_interval int
+var prescan bool
+
+// DefaultFlagSet returns a default `flag.FlagSet` for executing a command line application to emit records with a
+// `BucketIterator` instance. It extends the flagset defined by the `whosonfirst/go-whosonfirst-iterate/v3/app/emit`
+// package with bucket-specific flags.
+func DefaultFlagSet() *flag.FlagSet {
+
+	fs := iterate_emit.DefaultFlagSet()
+
+	fs.BoolVar(&progress, "progress", false, "Periodically report progress (keys, records, bytes, rates and ETA) to STDERR.")
+	fs.IntVar(&progress_interval, "progress-interval", 5, "The number of seconds between progress reports.")
+	fs.BoolVar(&prescan, "prescan", false, "Scan the bucket listing, before iterating, to count the total number of keys and bytes to process. Progress reports will include totals and an ETA.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Emit records in one or more URIs using a bucket iterator as structured data.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] uri(N) uri(N)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
@@ -0,0 +1,31 @@
+package invalidate
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sfomuseum/go-flags/flagset"
+)
+
+var iterator_uri string
+var verbose bool
+
+// DefaultFlagSet returns a default `flag.FlagSet` for executing a command line application
+// to invalidate the cached listings of a `BucketIterator` instance.
+func DefaultFlagSet() *flag.FlagSet {
+
+	fs := flagset.NewFlagSet("invalidate")
+
+	fs.StringVar(&iterator_uri, "iterator-uri", "", "A valid bucket iterator URI. This must include a '?listing_cache=' parameter.")
+	fs.BoolVar(&verbose, "verbose", false, "Enable verbose (debug) logging.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Invalidate the cached bucket listings for one or more URIs.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] uri(N) uri(N)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
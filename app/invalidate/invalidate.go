@@ -0,0 +1,60 @@
+// Package invalidate provides a command line application to invalidate the cached listings of a `BucketIterator` instance.
+package invalidate
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3"
+)
+
+// Run will execute a command line application to invalidate the cached listings of a `BucketIterator` instance
+// using a default flagset.
+func Run(ctx context.Context) error {
+	fs := DefaultFlagSet()
+	return RunWithFlagSet(ctx, fs)
+}
+
+// RunWithFlagSet will execute a command line application to invalidate the cached listings of a `BucketIterator`
+// instance using 'fs'.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet) error {
+
+	flagset.Parse(fs)
+
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+		slog.Debug("Verbose logging enabled")
+	}
+
+	uris := fs.Args()
+
+	if len(uris) == 0 {
+		uris = []string{"."}
+	}
+
+	it, err := bucket.NewBucketIterator(ctx, iterator_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create bucket iterator, %w", err)
+	}
+
+	defer it.Close()
+
+	bucket_it, ok := it.(*bucket.BucketIterator)
+
+	if !ok {
+		return fmt.Errorf("Unexpected iterator type %T", it)
+	}
+
+	err = bucket_it.InvalidateListings(ctx, uris...)
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Invalidated cached listings", "count", len(uris))
+	return nil
+}
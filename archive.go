@@ -0,0 +1,471 @@
+package bucket
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"sync/atomic"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+const (
+	// ARCHIVE_NONE signals that an object is not a (supported) archive.
+	ARCHIVE_NONE string = ""
+	// ARCHIVE_TAR signals that an object is a (possibly compressed) tar archive.
+	ARCHIVE_TAR string = "tar"
+	// ARCHIVE_ZIP signals that an object is a zip archive.
+	ARCHIVE_ZIP string = "zip"
+)
+
+// zip_eocd_signature is the signature of a zip archive's "end of central directory" record.
+const zip_eocd_signature uint32 = 0x06054b50
+
+// zip_eocd_len is the length of a zip archive's "end of central directory" record, not including its comment.
+const zip_eocd_len int = 22
+
+// zip_max_tail_len is the maximum distance from the end of a zip archive to the start of its "end of central directory"
+// record which may be followed by a comment of up to 65535 bytes.
+const zip_max_tail_len int64 = int64(zip_eocd_len) + 65535
+
+// zip64_locator_signature is the signature of a zip64 archive's "end of central directory locator" record.
+const zip64_locator_signature uint32 = 0x07064b50
+
+// zip64_locator_len is the length of a zip64 archive's "end of central directory locator" record.
+const zip64_locator_len int = 20
+
+// zip64_eocd_signature is the signature of a zip64 archive's "end of central directory" record.
+const zip64_eocd_signature uint32 = 0x06064b50
+
+// zip64_eocd_len is the length of a zip64 archive's "end of central directory" record, not including its extensible data.
+const zip64_eocd_len int = 56
+
+// archiveFormat returns the archive format implied by the file extension of 'key'.
+func archiveFormat(key string) string {
+
+	key = strings.ToLower(key)
+
+	switch {
+	case strings.HasSuffix(key, ".zip"):
+		return ARCHIVE_ZIP
+	case strings.HasSuffix(key, ".tar"),
+		strings.HasSuffix(key, ".tgz"), strings.HasSuffix(key, ".tar.gz"),
+		strings.HasSuffix(key, ".tbz2"), strings.HasSuffix(key, ".tar.bz2"),
+		strings.HasSuffix(key, ".tzst"), strings.HasSuffix(key, ".tar.zst"):
+		return ARCHIVE_TAR
+	default:
+		return ARCHIVE_NONE
+	}
+}
+
+// isArchiveRecord returns a boolean value indicating whether the archive entry 'name' should be yielded as a record.
+func isArchiveRecord(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".geojson")
+}
+
+// archivePath returns the record path for the entry 'name' contained by the archive 'key'.
+func archivePath(key string, name string) string {
+	return fmt.Sprintf("%s#%s", key, name)
+}
+
+// archiveRecords returns an `iter.Seq2[*Record, error]` for each GeoJSON file contained by the archive 'obj'.
+func (it *BucketIterator) archiveRecords(ctx context.Context, obj *blob.ListObject) iter.Seq2[*iterate.Record, error] {
+
+	return func(yield func(rec *iterate.Record, err error) bool) {
+
+		switch archiveFormat(obj.Key) {
+		case ARCHIVE_TAR:
+			it.tarRecords(ctx, obj, yield)
+		case ARCHIVE_ZIP:
+			it.zipRecords(ctx, obj, yield)
+		default:
+			it.logger.Debug("Skip non-archive object", "key", obj.Key)
+			it.hooks.skip(ctx, obj.Key, SKIP_MODE)
+		}
+	}
+}
+
+// tarRecords streams the tar archive 'obj', decompressing it if necessary, and calls 'yield' for each GeoJSON file it contains.
+func (it *BucketIterator) tarRecords(ctx context.Context, obj *blob.ListObject, yield func(rec *iterate.Record, err error) bool) {
+
+	r, err := it.openStream(ctx, obj)
+
+	if err != nil {
+		yield(nil, err)
+		return
+	}
+
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			// pass
+		}
+
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to read next entry in %s, %w", obj.Key, err))
+			return
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !isArchiveRecord(hdr.Name) {
+			continue
+		}
+
+		path := archivePath(obj.Key, hdr.Name)
+
+		body, err := io.ReadAll(tr)
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to read %s, %w", path, err))
+			return
+		}
+
+		rec, err := it.bytesRecord(ctx, path, body)
+
+		if err != nil {
+
+			if !yield(nil, err) {
+				return
+			}
+
+			continue
+		}
+
+		if rec == nil {
+			continue
+		}
+
+		if !yield(rec, nil) {
+			return
+		}
+	}
+}
+
+// zipRecords reads the zip archive 'obj', using a single range request to read its central directory (or two if the
+// central directory is very large) and one for each entry not already read along with it, and calls 'yield' for each
+// GeoJSON file it contains.
+func (it *BucketIterator) zipRecords(ctx context.Context, obj *blob.ListObject, yield func(rec *iterate.Record, err error) bool) {
+
+	size := obj.Size
+
+	// Objects derived from sources other than a bucket listing (for example "meta" files or
+	// dead-letter reports) do not have a size so fetch it from the object's attributes.
+
+	if size == 0 {
+
+		attrs, err := it.attributes(ctx, obj.Key)
+
+		if err != nil {
+			yield(nil, newObjectError(OP_HEAD, obj.Key, err))
+			return
+		}
+
+		size = attrs.Size
+	}
+
+	ra, err := it.newZipReaderAt(ctx, obj.Key, size)
+
+	if err != nil {
+		yield(nil, newObjectError(OP_GET, obj.Key, fmt.Errorf("Failed to read central directory for %s, %w", obj.Key, err)))
+		return
+	}
+
+	zr, err := zip.NewReader(ra, size)
+
+	if err != nil {
+		yield(nil, fmt.Errorf("Failed to open %s as a zip archive, %w", obj.Key, err))
+		return
+	}
+
+	it.metrics.ObjectOpened(ctx)
+	atomic.AddInt64(&it.stats.objects_opened, 1)
+
+	for _, f := range zr.File {
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			// pass
+		}
+
+		if f.FileInfo().IsDir() || !isArchiveRecord(f.Name) {
+			continue
+		}
+
+		path := archivePath(obj.Key, f.Name)
+
+		body, err := it.readZipEntry(ctx, obj.Key, ra, f)
+
+		if err != nil {
+
+			if !yield(nil, fmt.Errorf("Failed to read %s, %w", path, err)) {
+				return
+			}
+
+			continue
+		}
+
+		rec, err := it.bytesRecord(ctx, path, body)
+
+		if err != nil {
+
+			if !yield(nil, err) {
+				return
+			}
+
+			continue
+		}
+
+		if rec == nil {
+			continue
+		}
+
+		if !yield(rec, nil) {
+			return
+		}
+	}
+}
+
+// readZipEntry reads the body of the zip entry 'f' contained by 'key' from the bytes already read by 'ra', if possible, or
+// using a single range request.
+func (it *BucketIterator) readZipEntry(ctx context.Context, key string, ra *zipReaderAt, f *zip.File) ([]byte, error) {
+
+	offset, err := f.DataOffset()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine data offset, %w", err)
+	}
+
+	var r io.Reader
+
+	buf, ok := ra.buffered(offset, int64(f.CompressedSize64))
+
+	if ok {
+		r = bytes.NewReader(buf)
+	} else {
+
+		rr, err := it.newRangeReader(ctx, key, offset, int64(f.CompressedSize64))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer rr.Close()
+		r = rr
+	}
+
+	var entry_r io.Reader
+
+	switch f.Method {
+	case zip.Store:
+		entry_r = r
+	case zip.Deflate:
+		fr := flate.NewReader(r)
+		defer fr.Close()
+		entry_r = fr
+	default:
+		return nil, fmt.Errorf("Unsupported compression method %d", f.Method)
+	}
+
+	return io.ReadAll(entry_r)
+}
+
+// bucketReaderAt implements the `io.ReaderAt` interface for an object in a `gocloud.dev/blob.Bucket` using range requests.
+type bucketReaderAt struct {
+	ctx      context.Context
+	iterator *BucketIterator
+	key      string
+}
+
+// ReadAt reads len(p) bytes starting at 'offset' using a range request.
+func (r *bucketReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+
+	rr, err := r.iterator.newRangeReader(r.ctx, r.key, offset, int64(len(p)))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rr.Close()
+
+	n, err := io.ReadFull(rr, p)
+
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// zipReaderAt implements the `io.ReaderAt` interface for a zip archive in a `gocloud.dev/blob.Bucket` serving reads from
+// the end of the archive, which contains its central directory, from memory and everything else using range requests.
+type zipReaderAt struct {
+	// buf holds the bytes of the archive starting at 'offset'.
+	buf []byte
+	// offset is the position in the archive of the first byte in 'buf'.
+	offset int64
+	// fallback is used to read anything not contained by 'buf'.
+	fallback io.ReaderAt
+}
+
+// newZipReaderAt returns a new `zipReaderAt` instance for the zip archive 'key' whose size is 'size'. The end of the
+// archive, which is long enough to contain the "end of central directory" record and the longest possible comment, is
+// read using a single range request. If the central directory starts before that it is read using a second one.
+func (it *BucketIterator) newZipReaderAt(ctx context.Context, key string, size int64) (*zipReaderAt, error) {
+
+	ra := &zipReaderAt{
+		fallback: &bucketReaderAt{
+			ctx:      ctx,
+			iterator: it,
+			key:      key,
+		},
+	}
+
+	tail_len := min(size, zip_max_tail_len)
+	tail_offset := size - tail_len
+
+	tail, err := it.readRange(ctx, key, tail_offset, tail_len)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ra.buf = tail
+	ra.offset = tail_offset
+
+	// If the central directory can not be found let `zip.NewReader` report the problem
+
+	cd_offset, ok := zipDirectoryOffset(ra)
+
+	if !ok || cd_offset >= tail_offset || cd_offset < 0 {
+		return ra, nil
+	}
+
+	head, err := it.readRange(ctx, key, cd_offset, tail_offset-cd_offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ra.buf = append(head, tail...)
+	ra.offset = cd_offset
+
+	return ra, nil
+}
+
+// readRange reads 'length' bytes starting at 'offset' from 'key' using a single range request.
+func (it *BucketIterator) readRange(ctx context.Context, key string, offset int64, length int64) ([]byte, error) {
+
+	r, err := it.newRangeReader(ctx, key, offset, length)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// zipDirectoryOffset returns the offset of the central directory of the zip archive read by 'ra', whose buffer must
+// contain the end of the archive, and a boolean value indicating whether it could be determined.
+func zipDirectoryOffset(ra *zipReaderAt) (int64, bool) {
+
+	buf := ra.buf
+
+	// Find the last "end of central directory" record whose comment fits in the buffer
+
+	eocd := -1
+
+	for i := len(buf) - zip_eocd_len; i >= 0; i-- {
+
+		if binary.LittleEndian.Uint32(buf[i:]) != zip_eocd_signature {
+			continue
+		}
+
+		comment_len := int(binary.LittleEndian.Uint16(buf[i+20:]))
+
+		if i+zip_eocd_len+comment_len <= len(buf) {
+			eocd = i
+			break
+		}
+	}
+
+	if eocd == -1 {
+		return 0, false
+	}
+
+	cd_offset := binary.LittleEndian.Uint32(buf[eocd+16:])
+
+	if cd_offset != 0xffffffff {
+		return int64(cd_offset), true
+	}
+
+	// Zip64 archives record the offset in a separate record whose position is recorded by a "locator" record which
+	// immediately precedes the "end of central directory" record
+
+	locator := eocd - zip64_locator_len
+
+	if locator < 0 || binary.LittleEndian.Uint32(buf[locator:]) != zip64_locator_signature {
+		return 0, false
+	}
+
+	eocd64_offset := int64(binary.LittleEndian.Uint64(buf[locator+8:]))
+
+	eocd64 := make([]byte, zip64_eocd_len)
+
+	_, err := ra.ReadAt(eocd64, eocd64_offset)
+
+	if err != nil || binary.LittleEndian.Uint32(eocd64) != zip64_eocd_signature {
+		return 0, false
+	}
+
+	return int64(binary.LittleEndian.Uint64(eocd64[48:])), true
+}
+
+// buffered returns the 'length' bytes starting at 'offset' if they are contained by the buffer and a boolean value
+// indicating whether they were.
+func (r *zipReaderAt) buffered(offset int64, length int64) ([]byte, bool) {
+
+	start := offset - r.offset
+	end := start + length
+
+	if start < 0 || length < 0 || end > int64(len(r.buf)) {
+		return nil, false
+	}
+
+	return r.buf[start:end], true
+}
+
+// ReadAt reads len(p) bytes starting at 'offset' from the buffer, if it contains them, or using a range request.
+func (r *zipReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+
+	buf, ok := r.buffered(offset, int64(len(p)))
+
+	if ok {
+		return copy(p, buf), nil
+	}
+
+	return r.fallback.ReadAt(p, offset)
+}
@@ -0,0 +1,249 @@
+package bucket
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+func TestBucketIteratorArchive(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/archives")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?mode=archive", abs_path)
+
+	it, err := iterate.NewIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	counts := map[string]int{}
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		defer rec.Body.Close()
+
+		parts := strings.SplitN(rec.Path, "#", 2)
+
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "data/") {
+			t.Fatalf("Unexpected path '%s'", rec.Path)
+		}
+
+		body, err := io.ReadAll(rec.Body)
+
+		if err != nil {
+			t.Fatalf("Failed to read body for %s, %v", rec.Path, err)
+		}
+
+		if !json.Valid(body) {
+			t.Fatalf("Body for %s is not valid JSON", rec.Path)
+		}
+
+		counts[parts[0]] += 1
+	}
+
+	expected := map[string]int{
+		"archive.tar.gz": 4,
+		"archive.zip":    5,
+	}
+
+	for k, v := range expected {
+
+		if counts[k] != v {
+			t.Fatalf("Expected %d records in %s, but counted %d", v, k, counts[k])
+		}
+	}
+}
+
+func TestBucketIteratorZipRequests(t *testing.T) {
+
+	ctx := context.Background()
+
+	// newZip returns a zip archive containing 'count' GeoJSON files, with long names so that the central
+	// directory for large counts is bigger than the end of the archive read by the first range request.
+
+	newZip := func(count int) string {
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+
+		for i := 0; i < count; i++ {
+
+			name := fmt.Sprintf("data/%s/%06d.geojson", strings.Repeat("x", 64), i)
+
+			wr, err := zw.Create(name)
+
+			if err != nil {
+				t.Fatalf("Failed to create zip entry, %v", err)
+			}
+
+			_, err = fmt.Fprintf(wr, `{"type": "Feature", "id": %d}`, i)
+
+			if err != nil {
+				t.Fatalf("Failed to write zip entry, %v", err)
+			}
+		}
+
+		err := zw.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close zip archive, %v", err)
+		}
+
+		return buf.String()
+	}
+
+	tests := []struct {
+		label     string
+		count     int
+		get_calls func(count int) int
+	}{
+		// The whole archive fits in the first range request
+		{"small", 50, func(count int) int { return 1 }},
+		// The end of the archive and the rest of the central directory are read with one request each and every
+		// entry (which all precede the central directory) with two: one for its header and one for its body
+		{"large", 1000, func(count int) int { return 2 + (2 * count) }},
+	}
+
+	for _, test := range tests {
+
+		objects := map[string]string{
+			"archive.zip": newZip(test.count),
+		}
+
+		fb := newFakeBucket(objects)
+
+		opts := DefaultBucketIteratorOptions()
+		opts.Mode = MODE_ARCHIVE
+
+		it, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+		if err != nil {
+			t.Fatalf("Failed to create iterator, %v", err)
+		}
+
+		count := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate %s archive, %v", test.label, err)
+			}
+
+			body, err := io.ReadAll(rec.Body)
+			rec.Body.Close()
+
+			if err != nil || !json.Valid(body) {
+				t.Fatalf("Invalid body for %s, %v", rec.Path, err)
+			}
+
+			count += 1
+		}
+
+		it.Close()
+
+		if count != test.count {
+			t.Fatalf("Expected %d records in %s archive, but counted %d", test.count, test.label, count)
+		}
+
+		_, get_calls := fb.Calls()
+		expected := test.get_calls(test.count)
+
+		if get_calls != expected {
+			t.Fatalf("Expected %d read requests for %s archive, but counted %d", expected, test.label, get_calls)
+		}
+	}
+}
+
+func TestZipDirectoryOffset(t *testing.T) {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	wr, err := zw.Create("data/1.geojson")
+
+	if err != nil {
+		t.Fatalf("Failed to create zip entry, %v", err)
+	}
+
+	wr.Write([]byte(`{"type": "Feature"}`))
+
+	err = zw.SetComment("Hello world")
+
+	if err != nil {
+		t.Fatalf("Failed to set comment, %v", err)
+	}
+
+	err = zw.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close zip archive, %v", err)
+	}
+
+	body := buf.Bytes()
+
+	// The central directory starts with the first "central directory file header" record
+
+	expected := int64(bytes.Index(body, []byte{0x50, 0x4b, 0x01, 0x02}))
+
+	ra := &zipReaderAt{
+		buf: body,
+	}
+
+	offset, ok := zipDirectoryOffset(ra)
+
+	if !ok || offset != expected {
+		t.Fatalf("Expected central directory at %d, but got %d (%t)", expected, offset, ok)
+	}
+
+	// Rewrite the "end of central directory" record as a zip64 archive would
+
+	eocd := bytes.LastIndex(body, []byte{0x50, 0x4b, 0x05, 0x06})
+
+	eocd64 := make([]byte, zip64_eocd_len)
+	binary.LittleEndian.PutUint32(eocd64, zip64_eocd_signature)
+	binary.LittleEndian.PutUint64(eocd64[48:], uint64(expected))
+
+	locator := make([]byte, zip64_locator_len)
+	binary.LittleEndian.PutUint32(locator, zip64_locator_signature)
+	binary.LittleEndian.PutUint64(locator[8:], uint64(eocd))
+
+	record := bytes.Clone(body[eocd:])
+	binary.LittleEndian.PutUint32(record[16:], 0xffffffff)
+
+	body64 := slices.Concat(body[:eocd], eocd64, locator, record)
+
+	ra = &zipReaderAt{
+		buf: body64,
+	}
+
+	offset, ok = zipDirectoryOffset(ra)
+
+	if !ok || offset != expected {
+		t.Fatalf("Expected zip64 central directory at %d, but got %d (%t)", expected, offset, ok)
+	}
+}
@@ -0,0 +1,136 @@
+package bucket
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// THROUGHPUT_WINDOW is the length of the window over which the current read throughput is measured.
+const THROUGHPUT_WINDOW time.Duration = 1 * time.Second
+
+// throughputMeter measures the number of bytes read from a bucket, in total and per second.
+type throughputMeter struct {
+	// mu guards all the other fields.
+	mu *sync.Mutex
+	// total is the total number of bytes read.
+	total int64
+	// window_start is the time the current measurement window started.
+	window_start time.Time
+	// window_bytes is the number of bytes read during the current measurement window.
+	window_bytes int64
+	// rate is the throughput, in bytes per second, measured during the last complete window.
+	rate float64
+}
+
+// newThroughputMeter returns a new `throughputMeter` instance.
+func newThroughputMeter() *throughputMeter {
+
+	m := &throughputMeter{
+		mu:           new(sync.Mutex),
+		window_start: time.Now(),
+	}
+
+	return m
+}
+
+// Add records that 'n' bytes were read.
+func (m *throughputMeter) Add(n int64) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(time.Now())
+
+	m.total += n
+	m.window_bytes += n
+}
+
+// Total returns the total number of bytes read.
+func (m *throughputMeter) Total() int64 {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.total
+}
+
+// Rate returns the current throughput in bytes per second.
+func (m *throughputMeter) Rate() float64 {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(time.Now())
+	return m.rate
+}
+
+// roll starts a new measurement window, updating the current rate, if the current window is older than `THROUGHPUT_WINDOW`.
+func (m *throughputMeter) roll(now time.Time) {
+
+	elapsed := now.Sub(m.window_start)
+
+	if elapsed < THROUGHPUT_WINDOW {
+		return
+	}
+
+	m.rate = float64(m.window_bytes) / elapsed.Seconds()
+	m.window_start = now
+	m.window_bytes = 0
+}
+
+// meteredReader wraps an `io.ReadSeekCloser` for an object in a bucket recording the number of bytes read and, optionally,
+// throttling reads to the iterator's bandwidth limit.
+type meteredReader struct {
+	io.ReadSeekCloser
+	ctx     context.Context
+	meter   *throughputMeter
+	metrics *iteratorMetrics
+	limiter *rateLimiter
+}
+
+// Read reads up to len(p) bytes from the underlying reader and then waits until the bandwidth limiter, if present,
+// allows the bytes that were read.
+func (r *meteredReader) Read(p []byte) (int, error) {
+
+	n, err := r.ReadSeekCloser.Read(p)
+
+	if n > 0 {
+
+		r.meter.Add(int64(n))
+		r.metrics.BytesRead(r.ctx, int64(n))
+
+		wait_err := r.limiter.WaitN(r.ctx, float64(n))
+
+		if wait_err != nil {
+			return n, wait_err
+		}
+	}
+
+	return n, err
+}
+
+// meteredReader returns 'r' wrapped in a `meteredReader` instance using the iterator's throughput meter and bandwidth limiter.
+func (it *BucketIterator) meteredReader(ctx context.Context, r io.ReadSeekCloser) io.ReadSeekCloser {
+
+	mr := &meteredReader{
+		ReadSeekCloser: r,
+		ctx:            ctx,
+		meter:          it.throughput,
+		metrics:        it.metrics,
+		limiter:        it.bandwidth_limiter,
+	}
+
+	return mr
+}
+
+// BytesRead returns the total number of bytes read from the bucket. Bytes read from the local object cache are not included.
+func (it *BucketIterator) BytesRead() int64 {
+	return it.throughput.Total()
+}
+
+// Throughput returns the current combined throughput, in bytes per second, of all the object bodies being read from the bucket.
+func (it *BucketIterator) Throughput() float64 {
+	return it.throughput.Rate()
+}
@@ -0,0 +1,115 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBucketIteratorBandwidth(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s", abs_path)
+
+	iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	it := iter.(*BucketIterator)
+
+	total := int64(0)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		n, err := io.Copy(io.Discard, rec.Body)
+		rec.Body.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", rec.Path, err)
+		}
+
+		total += n
+	}
+
+	// Bodies may be read more than once (for example, when filters are applied) so
+	// the number of bytes read from the bucket may exceed the number of bytes consumed.
+
+	bytes_read := it.BytesRead()
+
+	if bytes_read < total {
+		t.Fatalf("Expected at least %d bytes read, but got %d", total, bytes_read)
+	}
+
+	// Limit reads to a third of the total so that, after the initial burst,
+	// reading everything again takes at least a second.
+
+	limit := bytes_read / 3
+
+	iter_uri = fmt.Sprintf("bucket-file://%s?max_bytes_per_second=%d", abs_path, limit)
+
+	throttled_iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create throttled bucket iterator, %v", err)
+	}
+
+	defer throttled_iter.Close()
+
+	throttled_it := throttled_iter.(*BucketIterator)
+
+	t0 := time.Now()
+
+	for rec, err := range throttled_it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		io.Copy(io.Discard, rec.Body)
+		rec.Body.Close()
+	}
+
+	if time.Since(t0) < 900*time.Millisecond {
+		t.Fatalf("Reads were not throttled, finished in %v", time.Since(t0))
+	}
+
+	if throttled_it.BytesRead() != bytes_read {
+		t.Fatalf("Expected %d bytes read, but got %d", bytes_read, throttled_it.BytesRead())
+	}
+}
+
+func TestThroughputMeter(t *testing.T) {
+
+	m := newThroughputMeter()
+	m.window_start = time.Now().Add(-2 * time.Second)
+	m.window_bytes = 2000
+	m.total = 2000
+
+	rate := m.Rate()
+
+	if rate < 900 || rate > 1100 {
+		t.Fatalf("Expected a rate of approximately 1000 bytes per second, but got %f", rate)
+	}
+
+	if m.Total() != 2000 {
+		t.Fatalf("Expected 2000 bytes, but got %d", m.Total())
+	}
+}
@@ -1,20 +1,65 @@
 package bucket
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"iter"
 	"log/slog"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/aaronland/go-json-query"
+	"github.com/whosonfirst/go-ioutil"
 	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3/filters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gocloud.dev/blob"
 )
 
 const PREFIX string = "bucket-"
 
+// MODE_OBJECT signals that each object in a bucket should be yielded as a single record.
+const MODE_OBJECT string = "object"
+
+// MODE_ARCHIVE signals that each tar or zip archive in a bucket should be yielded as one record per GeoJSON file it contains.
+const MODE_ARCHIVE string = "archive"
+
+// MODE_GEOJSONL signals that each line-delimited GeoJSON object in a bucket should be yielded as one record per line.
+const MODE_GEOJSONL string = "geojsonl"
+
+// MODE_FEATURECOLLECTION signals that each GeoJSON FeatureCollection object in a bucket should be yielded as one record per feature.
+const MODE_FEATURECOLLECTION string = "featurecollection"
+
+// SOURCE_LIST signals that the objects to process should be derived by listing the keys in a bucket.
+const SOURCE_LIST string = "list"
+
+// SOURCE_META signals that the objects to process should be derived from the rows in Who's On First "meta" CSV files stored in a bucket.
+const SOURCE_META string = "meta"
+
+// FILTER_INCLUDE is the name used to report records excluded because they did not match the iterator's "include" rules.
+const FILTER_INCLUDE string = "include"
+
+// FILTER_EXCLUDE is the name used to report records excluded because they matched the iterator's "exclude" rules.
+const FILTER_EXCLUDE string = "exclude"
+
+// FILTER_OTHER is the name used to report records excluded by filters other than the default query filters.
+const FILTER_OTHER string = "filters"
+
+// FILTER_ITERATOR is the name used to report records excluded by the `whosonfirst/go-whosonfirst-iterate/v3` concurrent
+// iterator which wraps the bucket iterator (for example using the `_exclude` or `_dedupe` parameters).
+const FILTER_ITERATOR string = "iterator"
+
+// SOURCE_DEAD_LETTER signals that the objects to process should be derived from the keys in a dead-letter report written by a previous run.
+const SOURCE_DEAD_LETTER string = "dead_letter"
+
 // In principle this could also be done with a sync.OnceFunc call but that will
 // require that everyone uses Go 1.21 (whose package import changes broke everything)
 // which is literally days old as I write this. So maybe a few releases after 1.21.
@@ -23,14 +68,131 @@ const PREFIX string = "bucket-"
 // if and when multiple gomail-sender instances register themselves.
 
 var register_mu = new(sync.RWMutex)
-var register_map = map[string]bool{}
+
+// register_map maps each registered scheme to the `gocloud.dev/blob.URLMux` used to open its buckets.
+var register_map = map[string]*blob.URLMux{}
+
+// iterator_params is the list of query parameters consumed by `BucketIterator` (and the filters it uses)
+// which are removed before the URI is handed to `gocloud.dev/blob.OpenBucket`. Parameters prefixed with
+// "_" are reserved by the `whosonfirst/go-whosonfirst-iterate/v3` package and are removed as well.
+var iterator_params = []string{
+	"include",
+	"exclude",
+	"include_mode",
+	"exclude_mode",
+	"decompress",
+	"dry_run",
+	"check_content_encoding",
+	"mode",
+	"source",
+	"meta_placetype",
+	"meta_is_current",
+	"meta_lastmodified_min",
+	"meta_lastmodified_max",
+	"cache_dir",
+	"cache_max_bytes",
+	"max_retries",
+	"retry_initial_delay",
+	"retry_max_delay",
+	"listing_cache",
+	"listing_cache_ttl",
+	"resume",
+	"max_list_rps",
+	"max_get_rps",
+	"max_bytes_per_second",
+	"adaptive_concurrency",
+	"min_get_concurrency",
+	"max_get_concurrency",
+	"min_list_concurrency",
+	"max_list_concurrency",
+	"adaptive_latency_target",
+	"on_error",
+	"dead_letter",
+	"dead_letter_key",
+}
 
 // BucketIterator implements the `Iterator` interface for crawling records in a `gocloud.dev/blob.Bucket` bucket.
 type BucketIterator struct {
 	// bucket is the `gocloud.dev/blob.Bucket` instance where records are stored.
 	bucket *blob.Bucket
-	// iterator is the underlying `DirectoryIterator` instance for crawling records.
+	// iterator is the `whosonfirst/go-whosonfirst-iterate/v3` concurrent iterator which wraps an `objectIterator` instance
+	// for 'bucket' and applies the "_"-prefixed parameters (path filters, deduplication, retries and concurrency).
 	iterator iterate.Iterator
+	// bucket_uri is the `gocloud.dev/blob` URI used to open 'bucket'.
+	bucket_uri string
+	// owns_bucket is a boolean flag indicating whether 'bucket' was opened by the iterator, and should be closed by `Close`.
+	owns_bucket bool
+	// cache is an optional `objectCache` instance used to store the bodies of objects on local disk.
+	cache *objectCache
+	// retry is the `retryPolicy` instance used to retry individual bucket operations which fail with transient errors.
+	retry *retryPolicy
+	// list_limiter is an optional `rateLimiter` instance used to limit the number of listing requests per second.
+	list_limiter *rateLimiter
+	// get_limiter is an optional `rateLimiter` instance used to limit the number of object read and attribute requests per second.
+	get_limiter *rateLimiter
+	// bandwidth_limiter is an optional `rateLimiter` instance used to limit the combined number of bytes per second read from the bucket.
+	bandwidth_limiter *rateLimiter
+	// get_concurrency is an optional `adaptiveLimiter` instance used to limit the number of concurrent object read and attribute requests.
+	get_concurrency *adaptiveLimiter
+	// list_concurrency is an optional `adaptiveLimiter` instance used to limit the number of concurrent listing requests.
+	list_concurrency *adaptiveLimiter
+	// stats is the `iteratorStats` instance used to accumulate the counters reported by `Stats`.
+	stats *iteratorStats
+	// metrics is the `iteratorMetrics` instance used to record OpenTelemetry metrics.
+	metrics *iteratorMetrics
+	// tracer is the `trace.Tracer` instance used to create OpenTelemetry spans.
+	tracer trace.Tracer
+	// logger is the `slog.Logger` instance used to log listing, fetching, retry and stats messages.
+	logger *slog.Logger
+	// hooks is an optional `Hooks` instance whose callbacks are invoked at different points in the iterator's lifecycle.
+	hooks *Hooks
+	// throughput is the `throughputMeter` instance used to measure the number of bytes read from the bucket.
+	throughput *throughputMeter
+	// with_stats is a boolean flag indicating whether the iterator's stats should be logged periodically.
+	with_stats bool
+	// stats_interval is the interval at which the iterator's stats are logged.
+	stats_interval time.Duration
+	// stats_level is the (slog) level at which the iterator's stats are logged.
+	stats_level slog.Level
+	// on_error is the policy for handling errors processing individual objects.
+	on_error string
+	// dead_letters is an optional `deadLetters` instance used to record objects that failed (when 'on_error' is `ON_ERROR_COLLECT`)
+	// or to read the objects that failed during a previous run (when 'source' is `SOURCE_DEAD_LETTER`).
+	dead_letters *deadLetters
+	// resume is a boolean flag indicating whether a URI should be iterated again, resuming where it stopped, after a failed attempt.
+	resume bool
+	// resume_max_attempts is the maximum number of attempts to iterate a URI when 'resume' is true.
+	resume_max_attempts int
+	// resume_delay is the amount of time to wait before the first resumed attempt. It is multiplied by the number of attempts for subsequent ones.
+	resume_delay time.Duration
+	// listing_cache is an optional `listingCache` instance used to store bucket listings between runs.
+	listing_cache *listingCache
+	// filters is a `filters.Filters` instance used to include or exclude specific records from being crawled.
+	filters filters.Filters
+	// mode is the iteration mode which determines how objects in a bucket are converted in to records.
+	mode string
+	// source is the key source which determines how the objects to process are derived.
+	source string
+	// meta_filters is a `metaFilters` instance used to include or exclude rows in Who's On First "meta" CSV files when 'source' is `SOURCE_META`.
+	meta_filters *metaFilters
+	// dry_run is a boolean flag indicating whether records should be derived from the bucket listing alone, without opening any objects.
+	dry_run bool
+	// decompress is a boolean flag indicating whether compressed objects should be decompressed before being yielded.
+	decompress bool
+	// check_content_encoding is a boolean flag indicating whether an object's "Content-Encoding" attribute should be consulted when detecting compression.
+	check_content_encoding bool
+	// seen is the count of records yielded to the caller.
+	seen int64
+	// iterating is a boolean value indicating whether records are still being iterated.
+	iterating *atomic.Bool
+}
+
+// objectIterator implements the `Iterator` interface for the records derived from the objects in a `BucketIterator`
+// instance's bucket. It is wrapped by the `whosonfirst/go-whosonfirst-iterate/v3` concurrent iterator which may call
+// its `Iterate` method for different URIs simultaneously.
+type objectIterator struct {
+	// it is the `BucketIterator` instance whose objects are iterated.
+	it *BucketIterator
 }
 
 func init() {
@@ -43,30 +205,34 @@ func init() {
 	}
 }
 
-// RegisterSchemes will explicitly register all the schemes associated with the `gocloud.dev/blob` interface.
+// RegisterSchemes will explicitly register all the schemes associated with the `gocloud.dev/blob` interface, as well as
+// the catch-all `CATCHALL_SCHEME` scheme. It is safe to call more than once; schemes which have already been registered are skipped.
 func RegisterSchemes(ctx context.Context) error {
 
 	register_mu.Lock()
 	defer register_mu.Unlock()
 
-	for _, scheme := range blob.DefaultURLMux().BucketSchemes() {
+	mux := blob.DefaultURLMux()
 
-		scheme = PREFIX + scheme
-		slog.Debug("Register bucket iterator scheme", "scheme", scheme)
+	err := registerMuxSchemes(ctx, mux, PREFIX, NewBucketIterator)
 
-		_, exists := register_map[scheme]
+	if err != nil {
+		return err
+	}
 
-		if exists {
-			continue
-		}
+	_, exists := register_map[CATCHALL_SCHEME]
 
-		err := iterate.RegisterIterator(ctx, scheme, NewBucketIterator)
+	if !exists {
+
+		slog.Debug("Register bucket iterator scheme", "scheme", CATCHALL_SCHEME)
+
+		err := iterate.RegisterIterator(ctx, CATCHALL_SCHEME, NewBucketIterator)
 
 		if err != nil {
-			return fmt.Errorf("Failed to register blob writer for '%s', %w", scheme, err)
+			return fmt.Errorf("Failed to register blob writer for '%s', %w", CATCHALL_SCHEME, err)
 		}
 
-		register_map[scheme] = true
+		register_map[CATCHALL_SCHEME] = mux
 	}
 
 	return nil
@@ -76,67 +242,961 @@ func RegisterSchemes(ctx context.Context) error {
 //
 //	bucket-{SCHEME}://?{PARAMETERS}
 //
+// Or, using the catch-all scheme (see `CATCHALL_SCHEME`):
+//
+//	bucket://{SCHEME}/{REMAINDER}?{PARAMETERS}
+//
 // Where {SCHEME} is a registered `gocloud.dev/blob` driver and {PARAMETERS} may be:
 // * `?include=` Zero or more `aaronland/go-json-query` query strings containing rules that must match for a document to be considered for further processing.
 // * `?exclude=` Zero or more `aaronland/go-json-query`	query strings containing rules that if matched will prevent a document from being considered for further processing.
 // * `?include_mode=` A valid `aaronland/go-json-query` query mode string for testing inclusion rules.
 // * `?exclude_mode=` A valid `aaronland/go-json-query` query mode string for testing exclusion rules.
-// * `?processes=` An optional number assigning the maximum number of database rows that will be processed simultaneously. (Default is defined by `runtime.NumCPU()`.)
+// * `?dry_run=` A boolean value indicating whether to yield one record per object, whose body is the object's listing metadata (key, size, modification time and MD5 checksum) encoded as JSON, without opening any objects. The `?include=` and `?exclude=` parameters are applied to the metadata rather than the object, for example `?include=size=^[0-9]{1,3}$`. (Default is false.)
+// * `?decompress=` A boolean value indicating whether gzip, bzip2 or zstd compressed objects should be decompressed before being yielded. Compression is detected using the object's file extension or its leading ("magic") bytes. (Default is false.)
+// * `?check_content_encoding=` A boolean value indicating whether an object's "Content-Encoding" attribute should also be used to detect compression. This requires an additional request per object whose extension does not indicate compression. (Default is false.)
+// * `?source=` The source used to derive the objects to process. Valid options are "list" (list the keys in the bucket), "meta" (read the rows in Who's On First "meta" CSV files) and "dead_letter" (read the keys in a dead-letter report). (Default is "list".)
+// * `?meta_placetype=` Zero or more placetypes that rows in "meta" CSV files must match to be processed.
+// * `?meta_is_current=` Zero or more "is_current" values that rows in "meta" CSV files must match to be processed.
+// * `?meta_lastmodified_min=` The minimum (Unix) "lastmodified" time that rows in "meta" CSV files must match to be processed.
+// * `?meta_lastmodified_max=` The maximum (Unix) "lastmodified" time that rows in "meta" CSV files must match to be processed.
+// * `?cache_dir=` An optional path to a local directory used to cache the bodies of objects between runs. Bodies are keyed by bucket URI, key and their MD5 hash (or modification time and size) as reported by a bucket listing. Objects derived from "meta" files or dead-letter reports (see `?source=`) are not listed so they are never cached.
+// * `?cache_max_bytes=` The maximum combined size, in bytes, of all the bodies in the cache. Least-recently used bodies are evicted first. (Default is 1GB.)
+// * `?listing_cache=` An optional `gocloud.dev/blob` URI where bucket listings (keys and their attributes) are cached between runs.
+// * `?listing_cache_ttl=` The number of seconds a cached listing is considered fresh. (Default is 3600.)
+// * `?max_retries=` The maximum number of times individual listing pages and object reads are retried if they fail with a transient (ResourceExhausted, DeadlineExceeded or Internal) error. (Default is 3.)
+// * `?retry_initial_delay=` The maximum number of milliseconds to wait before the first retry. Subsequent delays are doubled, with jitter, for each retry. (Default is 100.)
+// * `?retry_max_delay=` The upper bound, in milliseconds, for the delay between retries. (Default is 10000.)
+// * `?max_list_rps=` The maximum number of listing requests per second, shared by all the goroutines using the iterator. (Default is unlimited.)
+// * `?max_get_rps=` The maximum number of object read and attribute requests per second, shared by all the goroutines using the iterator. (Default is unlimited.)
+// * `?max_bytes_per_second=` The maximum combined number of bytes per second read from the bucket, shared by all the goroutines using the iterator. (Default is unlimited.)
+// * `?adaptive_concurrency=` A boolean value indicating whether the number of concurrent object read, attribute and listing requests should be adjusted using additive-increase/multiplicative-decrease based on observed latency and throttling errors. In "object" mode objects are fetched ahead of the caller by a pool of workers sized by the current limit, each of which reads the body of the object it fetched in to memory before releasing its slot. (Default is false.)
+// * `?min_get_concurrency=` The lower bound for the number of concurrent object read and attribute requests when adaptive concurrency is enabled. (Default is 1.)
+// * `?max_get_concurrency=` The upper bound for the number of concurrent object read and attribute requests when adaptive concurrency is enabled. (Default is 32.)
+// * `?min_list_concurrency=` The lower bound for the number of concurrent listing requests when adaptive concurrency is enabled. (Default is 1.)
+// * `?max_list_concurrency=` The upper bound for the number of concurrent listing requests when adaptive concurrency is enabled. (Default is 8.)
+// * `?adaptive_latency_target=` The request latency, in milliseconds, above which adaptive concurrency is decreased. (Default is 1000.)
+// * `?_with_stats=`, `?_stats_interval=` and `?_stats_level=` These parameters, defined by the `whosonfirst/go-whosonfirst-iterate/v3` package, also control the periodic logging of bucket-specific stats (bytes read and current throughput) while records are being iterated. Unlike the `whosonfirst/go-whosonfirst-iterate/v3` package bucket-specific stats are only logged if `?_with_stats=true` is set explicitly.
+// * `?resume=` A boolean value indicating whether a URI that fails should be iterated again, up to a total of `?_max_retries=` attempts (default 3) waiting `?_retry_after=` seconds (default 10) (multiplied by the number of attempts) between them, resuming after the last object that was processed in full. (Default is the value of the `?_retry=` parameter.)
+// * `?on_error=` The policy for handling errors processing individual objects. Valid options are "fail" (yield the error), "skip" (log the error and skip the object) and "collect" (log the error, skip the object and add it to a dead-letter report). (Default is "fail".)
+// * `?dead_letter=` A `gocloud.dev/blob` URI where the dead-letter report is written when the iterator is closed (if "on_error" is "collect") or read from (if "source" is "dead_letter").
+// * `?dead_letter_key=` The key of the dead-letter report. (Default is "dead-letter.jsonl".)
+// * `?mode=` The iteration mode. Valid options are "object" (yield each object as a single record), "archive" (yield each ".geojson" file contained in tar and zip archives as individual records), "geojsonl" (yield each line of (optionally compressed) ".geojsonl" objects as individual records) and "featurecollection" (yield each feature of (optionally compressed) ".geojson" or ".json" FeatureCollection objects as individual records). (Default is "object".)
+//
+// Parameters prefixed with "_" are defined by `whosonfirst/go-whosonfirst-iterate/v3`. Any other parameters must be one of the query parameters
+// accepted by the `gocloud.dev/blob` driver for {SCHEME} (see `RegisterDriverParameters`) and are passed to it; an error listing the valid
+// parameters is returned for anything else. The parameters are mapped on to a `BucketIteratorOptions` instance which is validated before
+// the bucket is opened.
 func NewBucketIterator(ctx context.Context, uri string) (iterate.Iterator, error) {
 
 	u, err := url.Parse(uri)
 
 	if err != nil {
-		return nil, err
+		return nil, scrubError(err, uri)
 	}
 
-	u.Scheme = strings.Replace(u.Scheme, PREFIX, "", 1)
+	if u.Scheme == CATCHALL_SCHEME {
 
-	bucket_uri := u.String()
+		u, err = resolveCatchAllURI(u)
 
-	bucket, err := blob.OpenBucket(ctx, bucket_uri)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve catch-all URI, %w", scrubError(err, uri))
+		}
+	}
+
+	return newBucketIterator(ctx, blob.DefaultURLMux(), PREFIX, u)
+}
+
+// newBucketIterator returns a new `BucketIterator` for 'u', whose scheme is a `gocloud.dev/blob` scheme with 'prefix'
+// prepended, opening buckets with 'mux'.
+func newBucketIterator(ctx context.Context, mux *blob.URLMux, prefix string, u *url.URL) (iterate.Iterator, error) {
+
+	q := u.Query()
+
+	bucket_uri := deriveBucketURI(u, prefix)
+	scheme := strings.TrimPrefix(u.Scheme, prefix)
+
+	err := checkQueryParameters(q, scheme)
 
 	if err != nil {
 		return nil, err
 	}
 
-	fs_iter, err := iterate.NewFSIterator(ctx, bucket_uri, bucket)
+	opts, err := NewBucketIteratorOptionsFromQuery(ctx, q)
 
 	if err != nil {
 		return nil, err
 	}
 
-	it := &BucketIterator{
-		iterator: fs_iter,
-		bucket:   bucket,
+	opts.URLMux = mux
+	opts.BucketURI = bucket_uri
+
+	err = opts.Validate()
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid parameters, %w", err)
 	}
 
+	bucket, err := mux.OpenBucket(ctx, bucket_uri)
+
+	if err != nil {
+		return nil, scrubError(err, bucket_uri)
+	}
+
+	it, err := newBucketIteratorWithOptions(ctx, bucket, opts)
+
+	if err != nil {
+		bucket.Close()
+		return nil, err
+	}
+
+	it.owns_bucket = true
 	return it, nil
 }
 
+// deriveBucketURI returns the `gocloud.dev/blob` URI for 'u' which is a copy of 'u' with 'prefix' (for example "bucket-")
+// removed from its scheme and all of the iterator-specific query parameters removed.
+func deriveBucketURI(u *url.URL, prefix string) string {
+
+	bucket_u := *u
+	bucket_u.Scheme = strings.TrimPrefix(u.Scheme, prefix)
+
+	q := bucket_u.Query()
+
+	for _, k := range iterator_params {
+		q.Del(k)
+	}
+
+	for k := range q {
+		if strings.HasPrefix(k, "_") {
+			q.Del(k)
+		}
+	}
+
+	bucket_u.RawQuery = q.Encode()
+	return bucket_u.String()
+}
+
 // Iterate will return an `iter.Seq2[*Record, error]` for each record encountered in 'uris'.
 func (it *BucketIterator) Iterate(ctx context.Context, uris ...string) iter.Seq2[*iterate.Record, error] {
-	return it.iterator.Iterate(ctx, uris...)
+
+	return func(yield func(rec *iterate.Record, err error) bool) {
+
+		it.stats.Start()
+		defer it.stats.Finish()
+
+		stop_stats := it.startStats(ctx)
+		defer stop_stats()
+
+		it.iterating.Swap(true)
+		defer it.iterating.Swap(false)
+
+		ctx, span := it.startSpan(ctx, "bucket.iterate", attribute.StringSlice("bucket.uris", uris))
+		defer span.End()
+
+		record_errors := newRecordErrors()
+		ctx = withRecordErrors(ctx, record_errors)
+
+		yield_errors := func() bool {
+
+			for _, err := range record_errors.Drain() {
+
+				if !yield(nil, err) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		for rec, err := range it.iterator.Iterate(ctx, uris...) {
+
+			if !yield_errors() {
+				return
+			}
+
+			// Records are only counted once they reach the caller since the concurrent iterator
+			// may exclude them (for example using the `_include` or `_dedupe` parameters).
+
+			if err == nil {
+
+				body, ok := rec.Body.(*trackedBody)
+
+				if ok {
+					body.deliver()
+					rec = iterate.NewRecord(rec.Path, body.ReadSeekCloser)
+				}
+
+				atomic.AddInt64(&it.seen, 1)
+			}
+
+			if !yield(rec, err) {
+				return
+			}
+		}
+
+		yield_errors()
+	}
+}
+
+// Iterate will return an `iter.Seq2[*Record, error]` for each record derived from the objects in 'uris'.
+func (oi *objectIterator) Iterate(ctx context.Context, uris ...string) iter.Seq2[*iterate.Record, error] {
+
+	return func(yield func(rec *iterate.Record, err error) bool) {
+
+		for _, uri := range uris {
+
+			if !oi.it.iterateURI(ctx, uri, yield) {
+				return
+			}
+		}
+	}
+}
+
+// Seen() returns the total number of records yielded to the caller of the `BucketIterator` instance so far.
+func (oi *objectIterator) Seen() int64 {
+	return atomic.LoadInt64(&oi.it.seen)
+}
+
+// IsIterating() returns a boolean value indicating whether 'oi' is still processing documents.
+func (oi *objectIterator) IsIterating() bool {
+	return oi.it.iterating.Load()
+}
+
+// Close performs any implementation specific tasks before terminating the iterator. Resources are owned, and closed, by the
+// `BucketIterator` instance so this is a no-op.
+func (oi *objectIterator) Close() error {
+	return nil
+}
+
+// record_pending signals that a record has been yielded to the concurrent iterator but not (yet) to the caller.
+const record_pending int32 = 0
+
+// record_delivered signals that a record has been yielded to the caller.
+const record_delivered int32 = 1
+
+// record_discarded signals that a record was excluded by the concurrent iterator.
+const record_discarded int32 = 2
+
+// trackedBody wraps the body of a record yielded to the concurrent iterator recording whether it was delivered to the
+// caller of `BucketIterator.Iterate` or closed, because it was excluded, by the concurrent iterator.
+type trackedBody struct {
+	io.ReadSeekCloser
+	state *atomic.Int32
+}
+
+// newTrackedBody returns a new `trackedBody` instance wrapping 'rsc'.
+func newTrackedBody(rsc io.ReadSeekCloser) *trackedBody {
+
+	b := &trackedBody{
+		ReadSeekCloser: rsc,
+		state:          new(atomic.Int32),
+	}
+
+	return b
+}
+
+// Close closes the underlying body, recording that the record was discarded if it had not been delivered to the caller.
+func (b *trackedBody) Close() error {
+	b.state.CompareAndSwap(record_pending, record_discarded)
+	return b.ReadSeekCloser.Close()
 }
 
-// Seen() returns the total number of records processed so far.
+// deliver records that the record was delivered to the caller.
+func (b *trackedBody) deliver() {
+	b.state.CompareAndSwap(record_pending, record_delivered)
+}
+
+// discarded returns a boolean value indicating whether the record was discarded by the concurrent iterator.
+func (b *trackedBody) discarded() bool {
+	return b.state.Load() == record_discarded
+}
+
+// iterateURI calls 'yield' for each record encountered in 'uri' and returns false if iteration should stop.
+//
+// If resuming is enabled then an error which would otherwise be yielded causes 'uri' to be iterated again, up to the
+// iterator's maximum number of attempts, starting from where the failed attempt stopped: objects whose keys sort before,
+// or are equal to, the last key that was processed in full are skipped without being read and records which were already
+// yielded for the object being processed when the attempt failed are discarded. The state used to do this is local to
+// each call so every call to `Iterate` starts from the beginning.
+func (it *BucketIterator) iterateURI(ctx context.Context, uri string, yield func(rec *iterate.Record, err error) bool) bool {
+
+	logger := it.logger.With("uri", uri)
+
+	ctx = withMetricsPrefix(ctx, listingPrefix(uri))
+
+	ctx, span := it.startSpan(ctx, "bucket.uri", attribute.String("bucket.uri", scrubURI(uri)))
+	defer span.End()
+
+	if !it.resume {
+		ok, _ := it.iterateObjects(ctx, uri, nil, false, yield)
+		return ok
+	}
+
+	// Only listings are guaranteed to be in lexicographic order
+	state := newResumeState(it.source == SOURCE_LIST)
+
+	for attempts := 1; ; attempts++ {
+
+		retry := attempts < it.resume_max_attempts
+
+		ok, err := it.iterateObjects(ctx, uri, state, retry, yield)
+
+		if err == nil {
+			span.SetAttributes(attribute.Int("bucket.attempts", attempts))
+			return ok
+		}
+
+		span.AddEvent("bucket.resume", trace.WithAttributes(attribute.Int("bucket.attempt", attempts), attribute.String("bucket.error", err.Error())))
+
+		delay := it.resume_delay * time.Duration(attempts)
+
+		logger.Warn("Resume iterator after error", "attempt", attempts, "max attempts", it.resume_max_attempts, "last key", state.last_key, "delay", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			yield(nil, ctx.Err())
+			return false
+		case <-time.After(delay):
+			// pass
+		}
+	}
+}
+
+// iterateObjects calls 'yield' for each record derived from the objects in 'uri' which were not processed during a
+// previous attempt, according to 'state' (which may be nil), and returns a boolean value indicating whether iteration
+// should continue. If 'retry' is true the first error which would otherwise be yielded is returned instead, so that the
+// caller can try again, and iteration stops.
+func (it *BucketIterator) iterateObjects(ctx context.Context, uri string, state *resumeState, retry bool, yield func(rec *iterate.Record, err error) bool) (bool, error) {
+
+	logger := it.logger.With("uri", uri)
+
+	for f, err := range it.fetchObjects(ctx, uri, state) {
+
+		if err != nil {
+
+			logger.Error("Failed to derive objects", "error", err)
+			it.metrics.Error(ctx, err)
+			it.stats.Error(err)
+			it.hooks.error(ctx, "", err)
+
+			if retry {
+				return true, err
+			}
+
+			yield(nil, err)
+			return false, nil
+		}
+
+		obj := f.obj
+		obj_span := f.span
+
+		count := int64(0)
+		var obj_err error
+
+		for rec, err := range f.records {
+
+			if err != nil {
+
+				obj_err = err
+				it.metrics.Error(ctx, err)
+				it.stats.Error(err)
+				it.hooks.error(ctx, obj.Key, err)
+
+				if !it.handleObjectError(obj.Key, err) {
+					it.hooks.skip(ctx, obj.Key, SKIP_ERROR)
+					continue
+				}
+
+				// Errors for individual records are yielded to the caller directly, rather than
+				// through 'yield', so that they neither stop nor restart iteration of 'uri'.
+
+				var rec_err *recordError
+				record_errors, ok := recordErrorsFromContext(ctx)
+
+				if ok && errors.As(err, &rec_err) {
+					record_errors.Add(err)
+					continue
+				}
+
+				if retry {
+					endSpan(obj_span, err)
+					return true, err
+				}
+
+				if !yield(nil, err) {
+					endSpan(obj_span, err)
+					return false, nil
+				}
+
+				continue
+			}
+
+			count += 1
+
+			if state != nil && state.Yielded(obj.Key, count) {
+				logger.Debug("Discard record yielded during a previous attempt", "path", rec.Path)
+				rec.Body.Close()
+				continue
+			}
+
+			body := newTrackedBody(rec.Body)
+			rec.Body = body
+
+			ok := yield(rec, nil)
+
+			// The concurrent iterator closes the bodies of the records it excludes before 'yield' returns
+
+			if body.discarded() {
+				it.metrics.RecordFiltered(ctx, FILTER_ITERATOR)
+				it.stats.Filtered(FILTER_ITERATOR)
+				it.hooks.skip(ctx, rec.Path, FILTER_ITERATOR)
+			} else {
+				it.hooks.yield(ctx, rec)
+			}
+
+			if !ok {
+				obj_span.End()
+				return false, nil
+			}
+		}
+
+		obj_span.SetAttributes(attribute.Int64("bucket.records", count))
+		endSpan(obj_span, obj_err)
+
+		atomic.AddInt64(&it.stats.keys_processed, 1)
+
+		if state != nil {
+			state.Complete(obj.Key)
+		}
+	}
+
+	return true, nil
+}
+
+// acceptObject records that 'obj' was derived from 'uri' and returns a boolean value indicating whether it should be
+// processed. Objects which were processed during a previous attempt, according to 'state' (which may be nil), or which
+// are vetoed by the iterator's hooks are skipped.
+func (it *BucketIterator) acceptObject(ctx context.Context, uri string, obj *blob.ListObject, state *resumeState) bool {
+
+	it.metrics.KeyListed(ctx)
+	atomic.AddInt64(&it.stats.keys_listed, 1)
+
+	if state != nil && state.Skip(obj.Key) {
+		it.hooks.skip(ctx, obj.Key, SKIP_RESUMED)
+		return false
+	}
+
+	if !it.hooks.open(ctx, obj) {
+		it.logger.Debug("Skip object vetoed by hook", "uri", uri, "key", obj.Key)
+		it.hooks.skip(ctx, obj.Key, SKIP_VETOED)
+		atomic.AddInt64(&it.stats.keys_processed, 1)
+		return false
+	}
+
+	return true
+}
+
+// objects returns an `iter.Seq2[*blob.ListObject, error]` for each object to be processed for 'uri' according to the iterator's source.
+func (it *BucketIterator) objects(ctx context.Context, uri string) iter.Seq2[*blob.ListObject, error] {
+
+	switch it.source {
+	case SOURCE_META:
+		return it.metaObjects(ctx, uri)
+	case SOURCE_DEAD_LETTER:
+		return it.deadLetterObjects(ctx, uri)
+	default:
+		return it.listObjects(ctx, uri)
+	}
+}
+
+// listObjects returns an `iter.Seq2[*blob.ListObject, error]` for each object in the bucket contained by 'uri', using
+// the iterator's listing cache if present.
+func (it *BucketIterator) listObjects(ctx context.Context, uri string) iter.Seq2[*blob.ListObject, error] {
+
+	if it.listing_cache != nil {
+		return it.cachedListObjects(ctx, uri)
+	}
+
+	return it.listBucket(ctx, uri)
+}
+
+// listBucket returns an `iter.Seq2[*blob.ListObject, error]` for each object in the bucket contained by 'uri'. If
+// adaptive concurrency is enabled the "directories" immediately below 'uri' are listed concurrently.
+func (it *BucketIterator) listBucket(ctx context.Context, uri string) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(obj *blob.ListObject, err error) bool) {
+
+		prefix := listingPrefix(uri)
+
+		var objects iter.Seq2[*blob.ListObject, error]
+
+		if it.list_concurrency != nil {
+			objects = it.listPrefixConcurrently(ctx, prefix)
+		} else {
+			objects = it.listPrefix(ctx, prefix, "")
+		}
+
+		for obj, err := range objects {
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to list bucket for '%s', %w", uri, err))
+				return
+			}
+
+			if obj.IsDir || !matchesPrefix(obj.Key, prefix) {
+				continue
+			}
+
+			if !yield(obj, nil) {
+				return
+			}
+		}
+	}
+}
+
+// listPrefix returns an `iter.Seq2[*blob.ListObject, error]` for each entry in the listing of 'prefix' using 'delimiter'
+// (which may be empty), page by page.
+func (it *BucketIterator) listPrefix(ctx context.Context, prefix string, delimiter string) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(obj *blob.ListObject, err error) bool) {
+
+		list_opts := &blob.ListOptions{
+			Prefix:    prefix,
+			Delimiter: delimiter,
+		}
+
+		token := blob.FirstPageToken
+
+		for len(token) > 0 {
+
+			page, err := it.listPage(ctx, token, list_opts)
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			objects := page.objects
+
+			if delimiter != "" {
+
+				objects = make([]*blob.ListObject, 0, len(page.objects))
+
+				for _, obj := range page.objects {
+
+					if !obj.IsDir {
+						objects = append(objects, obj)
+					}
+				}
+			}
+
+			it.hooks.list(ctx, prefix, objects)
+
+			for _, obj := range page.objects {
+
+				if !yield(obj, nil) {
+					return
+				}
+			}
+
+			token = page.next_token
+		}
+	}
+}
+
+// objectRecords returns an `iter.Seq2[*Record, error]` for each record derived from 'obj' according to the iterator's mode (or dry-run setting).
+func (it *BucketIterator) objectRecords(ctx context.Context, obj *blob.ListObject) iter.Seq2[*iterate.Record, error] {
+
+	if it.dry_run {
+		return it.dryRunRecords(ctx, obj)
+	}
+
+	switch it.mode {
+	case MODE_ARCHIVE:
+		return it.archiveRecords(ctx, obj)
+	case MODE_GEOJSONL:
+		return it.geojsonlRecords(ctx, obj)
+	case MODE_FEATURECOLLECTION:
+		return it.featureCollectionRecords(ctx, obj)
+	default:
+
+		return func(yield func(rec *iterate.Record, err error) bool) {
+
+			rec, err := it.openRecord(ctx, obj)
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if rec == nil {
+				return
+			}
+
+			yield(rec, nil)
+		}
+	}
+}
+
+// openObject opens 'obj' for reading, from the iterator's object cache if possible.
+func (it *BucketIterator) openObject(ctx context.Context, obj *blob.ListObject) (io.ReadSeekCloser, error) {
+
+	r, err := it.openCachedObject(ctx, obj)
+
+	if err != nil {
+		return nil, err
+	}
+
+	it.metrics.ObjectOpened(ctx)
+	atomic.AddInt64(&it.stats.objects_opened, 1)
+
+	return r, nil
+}
+
+// openCachedObject opens 'obj' for reading from the iterator's object cache, if present, falling back to the bucket
+// (and populating the cache) if the object is not cached. If the object can not be written to the cache it is read
+// from the bucket again, without being cached.
+func (it *BucketIterator) openCachedObject(ctx context.Context, obj *blob.ListObject) (io.ReadSeekCloser, error) {
+
+	if it.cache == nil {
+		return it.newReader(ctx, obj.Key)
+	}
+
+	cache_key, ok := it.cache.Key(it.bucket_uri, obj)
+
+	if !ok {
+		return it.newReader(ctx, obj.Key)
+	}
+
+	fh, ok := it.cache.Get(cache_key)
+
+	if ok {
+		it.logger.Debug("Read object from cache", "key", obj.Key)
+		atomic.AddInt64(&it.stats.cache_hits, 1)
+		return fh, nil
+	}
+
+	atomic.AddInt64(&it.stats.cache_misses, 1)
+
+	r, err := it.newReader(ctx, obj.Key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err = it.cache.Put(cache_key, r)
+	r.Close()
+
+	if err == nil {
+		return fh, nil
+	}
+
+	it.logger.Warn("Failed to write object to cache, reading from bucket instead", "key", obj.Key, "error", err)
+	return it.newReader(ctx, obj.Key)
+}
+
+// openRecord opens 'obj' and returns a new `iterate.Record` instance for it, or nil if the record was excluded by the iterator's filters.
+func (it *BucketIterator) openRecord(ctx context.Context, obj *blob.ListObject) (*iterate.Record, error) {
+
+	key := obj.Key
+
+	r, err := it.openObject(ctx, obj)
+
+	if err != nil {
+		return nil, newObjectError(OP_GET, key, fmt.Errorf("Failed to open %s for reading, %w", key, err))
+	}
+
+	var rsc io.ReadSeekCloser = r
+
+	if it.decompress {
+
+		compression, err := it.detectCompression(ctx, key)
+
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		br := bufio.NewReader(r)
+
+		if compression == COMPRESSION_NONE {
+			compression = sniffCompression(br)
+		}
+
+		if compression != COMPRESSION_NONE {
+
+			dr, err := newDecompressReader(br, compression)
+
+			if err != nil {
+				r.Close()
+				return nil, newObjectError(OP_DECOMPRESS, key, fmt.Errorf("Failed to create %s reader for %s, %w", compression, key, err))
+			}
+
+			body, err := io.ReadAll(dr)
+
+			dr.Close()
+			r.Close()
+
+			if err != nil {
+				return nil, newObjectError(OP_DECOMPRESS, key, fmt.Errorf("Failed to decompress %s, %w", key, err))
+			}
+
+			body_rsc, err := ioutil.NewReadSeekCloser(bytes.NewReader(body))
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create ReadSeekCloser for %s, %w", key, err)
+			}
+
+			rsc = &decompressedBody{
+				ReadSeekCloser: body_rsc,
+				compression:    compression,
+			}
+
+		} else {
+
+			_, err = r.Seek(0, io.SeekStart)
+
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("Failed to rewind %s, %w", key, err)
+			}
+		}
+	}
+
+	ok, err := it.applyFilters(ctx, key, rsc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	return iterate.NewRecord(key, rsc), nil
+}
+
+// bytesRecord returns a new `iterate.Record` instance for 'body', or nil if the record was excluded by the iterator's filters.
+func (it *BucketIterator) bytesRecord(ctx context.Context, path string, body []byte) (*iterate.Record, error) {
+
+	rsc, err := ioutil.NewReadSeekCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create ReadSeekCloser for %s, %w", path, err)
+	}
+
+	ok, err := it.applyFilters(ctx, path, rsc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	return iterate.NewRecord(path, rsc), nil
+}
+
+// applyFilters applies the iterator's filters to 'rsc' returning a boolean value indicating whether the record
+// should be yielded. If not, or if there is an error, 'rsc' will be closed.
+func (it *BucketIterator) applyFilters(ctx context.Context, path string, rsc io.ReadSeekCloser) (bool, error) {
+
+	if it.filters == nil {
+		return true, nil
+	}
+
+	ctx, span := it.startSpan(ctx, "bucket.filter", attribute.String("bucket.path", path))
+
+	ok, filter, err := it.matchFilters(ctx, rsc)
+
+	span.SetAttributes(attribute.Bool("bucket.included", ok))
+	endSpan(span, err)
+
+	if err != nil {
+		rsc.Close()
+		return false, newObjectError(OP_FILTER, path, fmt.Errorf("Failed to apply filters for '%s', %w", path, err))
+	}
+
+	if !ok {
+		rsc.Close()
+		it.metrics.RecordFiltered(ctx, filter)
+		it.stats.Filtered(filter)
+		it.hooks.skip(ctx, path, filter)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// matchFilters tests whether 'rsc' matches the iterator's filters, rewinding it afterwards, and returns a boolean value
+// indicating whether it matched along with the name of the filter which excluded it, if it did not. Unlike
+// `iterate.ApplyFilters` the body is not read at all if there are no include or exclude rules.
+func (it *BucketIterator) matchFilters(ctx context.Context, rsc io.ReadSeekCloser) (bool, string, error) {
+
+	qf, ok := it.filters.(*filters.QueryFilters)
+
+	if !ok {
+		ok, err := iterate.ApplyFilters(ctx, rsc, it.filters)
+		return ok, FILTER_OTHER, err
+	}
+
+	if qf.Include == nil && qf.Exclude == nil {
+		return true, "", nil
+	}
+
+	body, err := io.ReadAll(rsc)
+
+	if err != nil {
+		return false, "", fmt.Errorf("Failed to read document, %w", err)
+	}
+
+	if qf.Include != nil {
+
+		matches, err := query.Matches(ctx, qf.Include, body)
+
+		if err != nil {
+			return false, "", fmt.Errorf("Failed to perform includes matching, %w", err)
+		}
+
+		if !matches {
+			return false, FILTER_INCLUDE, nil
+		}
+	}
+
+	if qf.Exclude != nil {
+
+		matches, err := query.Matches(ctx, qf.Exclude, body)
+
+		if err != nil {
+			return false, "", fmt.Errorf("Failed to perform excludes matching, %w", err)
+		}
+
+		if matches {
+			return false, FILTER_EXCLUDE, nil
+		}
+	}
+
+	_, err = rsc.Seek(0, io.SeekStart)
+
+	if err != nil {
+		return false, "", fmt.Errorf("Failed to rewind document, %w", err)
+	}
+
+	return true, "", nil
+}
+
+// detectCompression returns the compression scheme for 'key' derived from its file extension or, if enabled, its "Content-Encoding" attribute.
+func (it *BucketIterator) detectCompression(ctx context.Context, key string) (string, error) {
+
+	compression := compressionFromExtension(key)
+
+	if compression != COMPRESSION_NONE || !it.check_content_encoding {
+		return compression, nil
+	}
+
+	attrs, err := it.attributes(ctx, key)
+
+	if err != nil {
+		return COMPRESSION_NONE, newObjectError(OP_HEAD, key, fmt.Errorf("Failed to retrieve attributes for %s, %w", key, err))
+	}
+
+	return compressionFromContentEncoding(attrs.ContentEncoding), nil
+}
+
+// listingPrefix returns the bucket listing prefix for 'uri' which is expected to be a path relative to the root of the bucket.
+func listingPrefix(uri string) string {
+
+	prefix := strings.TrimPrefix(uri, "./")
+	prefix = strings.Trim(prefix, "/")
+
+	if prefix == "." {
+		prefix = ""
+	}
+
+	return prefix
+}
+
+// matchesPrefix returns a boolean value indicating whether 'key' is equal to 'prefix' or is contained by the "directory" 'prefix'.
+func matchesPrefix(key string, prefix string) bool {
+
+	if prefix == "" || key == prefix {
+		return true
+	}
+
+	return strings.HasPrefix(key, prefix+"/")
+}
+
+// Seen() returns the total number of records yielded to the caller so far. Records excluded by the concurrent iterator
+// which wraps the bucket iterator (for example using the `_include`, `_exclude` or `_dedupe` parameters) are not counted.
 func (it *BucketIterator) Seen() int64 {
-	return it.iterator.Seen()
+	return atomic.LoadInt64(&it.seen)
 }
 
 // IsIterating() returns a boolean value indicating whether 'it' is still processing documents.
 func (it *BucketIterator) IsIterating() bool {
-	return it.iterator.IsIterating()
+	return it.iterating.Load()
 }
 
 // Close performs any implementation specific tasks before terminating the iterator.
 func (it *BucketIterator) Close() error {
 
-	err := it.iterator.Close()
+	var first_err error
 
-	if err != nil {
-		return err
+	if it.iterator != nil {
+		first_err = it.iterator.Close()
+	}
+
+	if it.dead_letters != nil && it.on_error == ON_ERROR_COLLECT {
+
+		err := it.dead_letters.Write(context.Background())
+
+		if err != nil && first_err == nil {
+			first_err = fmt.Errorf("Failed to write dead-letter report, %w", err)
+		}
+	}
+
+	err := it.closeResources()
+
+	if err != nil && first_err == nil {
+		first_err = err
+	}
+
+	if it.owns_bucket {
+
+		err := it.bucket.Close()
+
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+	}
+
+	return first_err
+}
+
+// closeResources closes the dead-letter and listing cache buckets opened by 'it', if present, returning the first
+// error encountered. Every bucket is closed even if closing an earlier one fails.
+func (it *BucketIterator) closeResources() error {
+
+	var first_err error
+
+	if it.dead_letters != nil {
+
+		err := it.dead_letters.Close()
+
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+	}
+
+	if it.listing_cache != nil {
+
+		err := it.listing_cache.Close()
+
+		if err != nil && first_err == nil {
+			first_err = err
+		}
 	}
 
-	return it.bucket.Close()
+	return first_err
 }
@@ -0,0 +1,321 @@
+package bucket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// DEFAULT_CACHE_MAX_BYTES is the default maximum size, in bytes, of an on-disk object cache.
+const DEFAULT_CACHE_MAX_BYTES int64 = 1024 * 1024 * 1024
+
+// cache_low_water is the fraction of its maximum size that an on-disk object cache is reduced to when it is evicted. Evicting
+// below the maximum size means that the cache directory is only walked once enough new bodies have been written to fill the
+// difference rather than every time a body is written to a full cache.
+const cache_low_water float64 = 0.9
+
+// cache_tmp_prefix is the prefix for temporary files written to an on-disk object cache. These are
+// renamed in to place once they have been written in full so that concurrent readers (in this or any
+// other process sharing the cache) never see partial bodies.
+const cache_tmp_prefix string = ".tmp-"
+
+// objectCache implements a size-capped, least-recently-used on-disk cache for the bodies of objects in
+// a `gocloud.dev/blob.Bucket`. It is safe for multiple processes to share the same cache directory.
+type objectCache struct {
+	// root is the directory where cached bodies are stored.
+	root string
+	// max_bytes is the maximum combined size of all the bodies in the cache.
+	max_bytes int64
+	// size is the (approximate) combined size of all the bodies in the cache.
+	size int64
+	// evict_mu ensures that only one eviction pass (per process) happens at a time.
+	evict_mu *sync.Mutex
+	// logger is the `slog.Logger` instance used to log cache operations.
+	logger *slog.Logger
+}
+
+// newObjectCache returns a new `objectCache` instance storing bodies in 'root' whose combined size will not exceed 'max_bytes'.
+func newObjectCache(root string, max_bytes int64) (*objectCache, error) {
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cache directory, %w", err)
+	}
+
+	c := &objectCache{
+		root:      root,
+		max_bytes: max_bytes,
+		evict_mu:  new(sync.Mutex),
+		logger:    slog.Default(),
+	}
+
+	size, err := c.diskSize()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine cache size, %w", err)
+	}
+
+	atomic.StoreInt64(&c.size, size)
+	return c, nil
+}
+
+// Key returns the cache key for 'obj' in the bucket identified by 'bucket_uri'. The key is derived from the object's MD5
+// hash or, if that is not available, its modification time and size as reported by a bucket listing. If none of these
+// are available the object can not be cached and the method returns false. This is always the case for objects derived
+// from "meta" files or dead-letter reports since they are not listed and fetching their attributes to derive a key would
+// cost as much as the request the cache is meant to save.
+func (c *objectCache) Key(bucket_uri string, obj *blob.ListObject) (string, bool) {
+
+	var version string
+
+	switch {
+	case len(obj.MD5) > 0:
+		version = "md5:" + hex.EncodeToString(obj.MD5)
+	case !obj.ModTime.IsZero():
+		version = "mtime:" + strconv.FormatInt(obj.ModTime.UnixNano(), 10) + ":" + strconv.FormatInt(obj.Size, 10)
+	default:
+		return "", false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(bucket_uri))
+	h.Write([]byte{0})
+	h.Write([]byte(obj.Key))
+	h.Write([]byte{0})
+	h.Write([]byte(version))
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// path returns the absolute path on disk for 'key'.
+func (c *objectCache) path(key string) string {
+	return filepath.Join(c.root, key[0:2], key)
+}
+
+// Get returns an open file handle for the body cached under 'key' and a boolean value indicating whether it was found.
+func (c *objectCache) Get(key string) (*os.File, bool) {
+
+	path := c.path(key)
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, false
+	}
+
+	// Modification times are used to determine which bodies were least-recently used
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+
+	if err != nil {
+		c.logger.Debug("Failed to update cache file times", "path", path, "error", err)
+	}
+
+	return fh, true
+}
+
+// Put stores the body read from 'r' under 'key' and returns an open file handle for it.
+func (c *objectCache) Put(key string, r io.Reader) (*os.File, error) {
+
+	path := c.path(key)
+	root := filepath.Dir(path)
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cache directory, %w", err)
+	}
+
+	tmp_fh, err := os.CreateTemp(root, cache_tmp_prefix+"*")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary cache file, %w", err)
+	}
+
+	tmp_path := tmp_fh.Name()
+
+	size, err := io.Copy(tmp_fh, r)
+
+	if err != nil {
+		tmp_fh.Close()
+		os.Remove(tmp_path)
+		return nil, fmt.Errorf("Failed to write cache file, %w", err)
+	}
+
+	err = tmp_fh.Close()
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return nil, fmt.Errorf("Failed to close cache file, %w", err)
+	}
+
+	// os.Rename is atomic (on POSIX systems) so if another process has written the same key
+	// in the meantime one (identical) body simply replaces the other.
+
+	err = os.Rename(tmp_path, path)
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return nil, fmt.Errorf("Failed to move cache file in to place, %w", err)
+	}
+
+	if atomic.AddInt64(&c.size, size) > c.max_bytes {
+		c.evict(key)
+	}
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open cache file, %w", err)
+	}
+
+	return fh, nil
+}
+
+// cacheFile is a file in the cache directory considered for eviction.
+type cacheFile struct {
+	path    string
+	size    int64
+	modtime time.Time
+}
+
+// evict removes the least-recently used bodies from the cache until its combined size is less than 'cache_low_water' of
+// 'c.max_bytes'. The body for 'keep' (which has just been written) is never removed.
+func (c *objectCache) evict(keep string) {
+
+	c.evict_mu.Lock()
+	defer c.evict_mu.Unlock()
+
+	// Another goroutine may have evicted the cache while this one was waiting for the lock
+
+	if atomic.LoadInt64(&c.size) <= c.max_bytes {
+		return
+	}
+
+	files, err := c.files()
+
+	if err != nil {
+		c.logger.Warn("Failed to read cache directory for eviction", "root", c.root, "error", err)
+		return
+	}
+
+	total := int64(0)
+
+	for _, f := range files {
+		total += f.size
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modtime.Before(files[j].modtime)
+	})
+
+	keep_path := c.path(keep)
+	low_water := int64(float64(c.max_bytes) * cache_low_water)
+
+	for _, f := range files {
+
+		if total <= low_water {
+			break
+		}
+
+		if f.path == keep_path {
+			continue
+		}
+
+		// Another process sharing the cache may have removed the file already
+		err := os.Remove(f.path)
+
+		if err != nil && !os.IsNotExist(err) {
+			c.logger.Warn("Failed to evict cache file", "path", f.path, "error", err)
+			continue
+		}
+
+		c.logger.Debug("Evicted cache file", "path", f.path, "size", f.size)
+		total -= f.size
+	}
+
+	atomic.StoreInt64(&c.size, total)
+}
+
+// diskSize returns the combined size of all the bodies in the cache directory.
+func (c *objectCache) diskSize() (int64, error) {
+
+	files, err := c.files()
+
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(0)
+
+	for _, f := range files {
+		total += f.size
+	}
+
+	return total, nil
+}
+
+// files returns the list of (non-temporary) bodies in the cache directory.
+func (c *objectCache) files() ([]*cacheFile, error) {
+
+	files := make([]*cacheFile, 0)
+
+	walk_func := func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+
+			// Another process sharing the cache may have removed the file already
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), cache_tmp_prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		f := &cacheFile{
+			path:    path,
+			size:    info.Size(),
+			modtime: info.ModTime(),
+		}
+
+		files = append(files, f)
+		return nil
+	}
+
+	err := filepath.WalkDir(c.root, walk_func)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
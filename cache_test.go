@@ -0,0 +1,316 @@
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+)
+
+func TestBucketIteratorCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	data_root := t.TempDir()
+
+	err = os.CopyFS(data_root, os.DirFS(abs_path))
+
+	if err != nil {
+		t.Fatalf("Failed to copy fixtures, %v", err)
+	}
+
+	cache_dir := t.TempDir()
+	expected := 37
+
+	// iterate_once returns the bodies of the records in the bucket, keyed by their path, and the iterator's stats
+
+	iterate_once := func() (map[string][]byte, *Stats) {
+
+		iter_uri := fmt.Sprintf("bucket-file://%s?cache_dir=%s", data_root, cache_dir)
+
+		it, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator, %v", err)
+		}
+
+		defer it.Close()
+
+		bodies := make(map[string][]byte)
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket, %v", err)
+			}
+
+			body, err := io.ReadAll(rec.Body)
+
+			if err != nil {
+				t.Fatalf("Failed to read body for %s, %v", rec.Path, err)
+			}
+
+			rec.Body.Close()
+			bodies[rec.Path] = body
+		}
+
+		return bodies, it.(*BucketIterator).Stats()
+	}
+
+	// The first run populates the cache
+
+	first, st := iterate_once()
+
+	if len(first) != expected {
+		t.Fatalf("Expected %d records, but counted %d", expected, len(first))
+	}
+
+	if st.CacheHits != 0 || st.CacheMisses != int64(expected) {
+		t.Fatalf("Expected 0 cache hits and %d misses, but got %d and %d", expected, st.CacheHits, st.CacheMisses)
+	}
+
+	c, err := newObjectCache(cache_dir, DEFAULT_CACHE_MAX_BYTES)
+
+	if err != nil {
+		t.Fatalf("Failed to create object cache, %v", err)
+	}
+
+	files, err := c.files()
+
+	if err != nil {
+		t.Fatalf("Failed to list cache files, %v", err)
+	}
+
+	if len(files) != expected {
+		t.Fatalf("Expected %d cache files, but counted %d", expected, len(files))
+	}
+
+	// Replace the objects in the bucket with garbage of the same size and modification time, so that they have the
+	// same cache keys but the second run would yield different bodies if it read them from the bucket
+
+	for path := range first {
+
+		abs_path := filepath.Join(data_root, path)
+
+		info, err := os.Stat(abs_path)
+
+		if err != nil {
+			t.Fatalf("Failed to stat %s, %v", path, err)
+		}
+
+		err = os.WriteFile(abs_path, bytes.Repeat([]byte("x"), int(info.Size())), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to overwrite %s, %v", path, err)
+		}
+
+		err = os.Chtimes(abs_path, info.ModTime(), info.ModTime())
+
+		if err != nil {
+			t.Fatalf("Failed to restore modification time for %s, %v", path, err)
+		}
+	}
+
+	// The second run reads every object from the cache
+
+	second, st := iterate_once()
+
+	if len(second) != expected {
+		t.Fatalf("Expected %d records, but counted %d", expected, len(second))
+	}
+
+	if st.CacheHits != int64(expected) || st.CacheMisses != 0 {
+		t.Fatalf("Expected %d cache hits and 0 misses, but got %d and %d", expected, st.CacheHits, st.CacheMisses)
+	}
+
+	for path, body := range first {
+
+		if !bytes.Equal(second[path], body) {
+			t.Fatalf("Expected %s to be read from the cache", path)
+		}
+	}
+}
+
+func TestObjectCacheEviction(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	cache_dir := t.TempDir()
+	max_bytes := int64(20000)
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?cache_dir=%s&cache_max_bytes=%d", abs_path, cache_dir, max_bytes)
+
+	it, err := iterate.NewIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	c, err := newObjectCache(cache_dir, max_bytes)
+
+	if err != nil {
+		t.Fatalf("Failed to create object cache, %v", err)
+	}
+
+	size, err := c.diskSize()
+
+	if err != nil {
+		t.Fatalf("Failed to determine cache size, %v", err)
+	}
+
+	if size > max_bytes {
+		t.Fatalf("Expected cache size to be <= %d, but was %d", max_bytes, size)
+	}
+}
+
+func TestObjectCacheLowWater(t *testing.T) {
+
+	cache_dir := t.TempDir()
+	max_bytes := int64(10000)
+
+	c, err := newObjectCache(cache_dir, max_bytes)
+
+	if err != nil {
+		t.Fatalf("Failed to create object cache, %v", err)
+	}
+
+	body := strings.Repeat("x", 1000)
+
+	put := func(i int) {
+
+		fh, err := c.Put(fmt.Sprintf("%064d", i), strings.NewReader(body))
+
+		if err != nil {
+			t.Fatalf("Failed to write body %d, %v", i, err)
+		}
+
+		fh.Close()
+	}
+
+	for i := 0; i < 11; i++ {
+		put(i)
+	}
+
+	// Writing the eleventh body evicts the cache down to its low-water mark rather than its maximum size
+
+	low_water := int64(float64(max_bytes) * cache_low_water)
+
+	if atomic.LoadInt64(&c.size) > low_water {
+		t.Fatalf("Expected cache size to be <= %d, but was %d", low_water, atomic.LoadInt64(&c.size))
+	}
+
+	files, err := c.files()
+
+	if err != nil {
+		t.Fatalf("Failed to list cache files, %v", err)
+	}
+
+	count := len(files)
+
+	// So the next body can be written without evicting anything
+
+	put(11)
+
+	files, err = c.files()
+
+	if err != nil {
+		t.Fatalf("Failed to list cache files, %v", err)
+	}
+
+	if len(files) != count+1 {
+		t.Fatalf("Expected %d cache files, but counted %d", count+1, len(files))
+	}
+}
+
+func TestBucketIteratorCacheWriteFailure(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	cache_dir := filepath.Join(t.TempDir(), "cache")
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?cache_dir=%s", abs_path, cache_dir)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	// Replace the cache directory with a file so that bodies can not be written to the cache
+
+	err = os.RemoveAll(cache_dir)
+
+	if err != nil {
+		t.Fatalf("Failed to remove cache directory, %v", err)
+	}
+
+	err = os.WriteFile(cache_dir, []byte("not a directory"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write cache file, %v", err)
+	}
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Expected records to be read from the bucket when the cache can not be written, %v", err)
+		}
+
+		body, err := io.ReadAll(rec.Body)
+		rec.Body.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read body for %s, %v", rec.Path, err)
+		}
+
+		if len(body) == 0 {
+			t.Fatalf("Expected a body for %s", rec.Path)
+		}
+
+		count += 1
+	}
+
+	if count != 37 {
+		t.Fatalf("Expected 37 records, but counted %d", count)
+	}
+}
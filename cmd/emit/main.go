@@ -4,8 +4,7 @@ import (
 	"context"
 	"log"
 
-	_ "github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3"
-	"github.com/whosonfirst/go-whosonfirst-iterate/v3/app/emit"
+	"github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3/app/emit"
 )
 
 func main() {
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3/app/invalidate"
+)
+
+func main() {
+
+	ctx := context.Background()
+	err := invalidate.Run(ctx)
+
+	if err != nil {
+		log.Fatalf("Failed to invalidate cached listings, %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ON_ERROR_FAIL signals that errors processing individual objects should be yielded to the caller.
+const ON_ERROR_FAIL string = "fail"
+
+// ON_ERROR_SKIP signals that errors processing individual objects should be logged and the object skipped.
+const ON_ERROR_SKIP string = "skip"
+
+// ON_ERROR_COLLECT signals that errors processing individual objects should be logged, the object skipped and
+// the error recorded in a dead-letter report written when the iterator is closed.
+const ON_ERROR_COLLECT string = "collect"
+
+// DEFAULT_DEAD_LETTER_KEY is the default key for dead-letter reports.
+const DEFAULT_DEAD_LETTER_KEY string = "dead-letter.jsonl"
+
+const (
+	// OP_GET signals an error opening an object for reading.
+	OP_GET string = "get"
+	// OP_HEAD signals an error retrieving the attributes of an object.
+	OP_HEAD string = "head"
+	// OP_DECOMPRESS signals an error decompressing an object.
+	OP_DECOMPRESS string = "decompress"
+	// OP_FILTER signals an error applying filters to a record.
+	OP_FILTER string = "filter"
+	// OP_READ signals an error reading or parsing the body of an object.
+	OP_READ string = "read"
+)
+
+// ObjectError is an error processing an individual object (or a record derived from it) in a bucket.
+type ObjectError struct {
+	// Key is the key (or record path) of the object that failed.
+	Key string
+	// Op is the operation that failed.
+	Op string
+	// Err is the underlying error.
+	Err error
+}
+
+// newObjectError returns a new `ObjectError` wrapping 'err' for the operation 'op' on 'key'.
+func newObjectError(op string, key string, err error) error {
+
+	e := &ObjectError{
+		Key: key,
+		Op:  op,
+		Err: err,
+	}
+
+	return e
+}
+
+// Error returns the message of the underlying error.
+func (e *ObjectError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetter is a single entry in a dead-letter report.
+type DeadLetter struct {
+	// Key is the key of the object that failed.
+	Key string `json:"key"`
+	// Path is the path of the record that failed, if different from Key.
+	Path string `json:"path,omitempty"`
+	// Op is the operation that failed.
+	Op string `json:"op"`
+	// Code is the `gocloud.dev/gcerrors` code for the error.
+	Code string `json:"code"`
+	// Error is the error message.
+	Error string `json:"error"`
+}
+
+// newDeadLetter returns a new `DeadLetter` instance for 'err' encountered while processing 'key'.
+func newDeadLetter(key string, err error) *DeadLetter {
+
+	dl := &DeadLetter{
+		Key:   key,
+		Op:    OP_READ,
+		Code:  gcerrors.Code(err).String(),
+		Error: err.Error(),
+	}
+
+	var obj_err *ObjectError
+
+	if errors.As(err, &obj_err) {
+
+		dl.Op = obj_err.Op
+
+		if obj_err.Key != key {
+			dl.Path = obj_err.Key
+		}
+	}
+
+	return dl
+}
+
+// deadLetters collects `DeadLetter` entries and reads and writes them as line-delimited JSON to a `gocloud.dev/blob.Bucket`.
+type deadLetters struct {
+	// bucket is the `gocloud.dev/blob.Bucket` where the dead-letter report is stored.
+	bucket *blob.Bucket
+	// key is the key of the dead-letter report.
+	key string
+	// mu guards 'entries'.
+	mu *sync.Mutex
+	// entries are the dead-letter entries collected so far.
+	entries []*DeadLetter
+}
+
+// newDeadLetters returns a new `deadLetters` instance for the report 'key' in the bucket defined by 'uri' and opened with 'mux'.
+func newDeadLetters(ctx context.Context, mux *blob.URLMux, uri string, key string) (*deadLetters, error) {
+
+	b, err := mux.OpenBucket(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open dead-letter bucket, %w", scrubError(err, uri))
+	}
+
+	dl := &deadLetters{
+		bucket:  b,
+		key:     key,
+		mu:      new(sync.Mutex),
+		entries: make([]*DeadLetter, 0),
+	}
+
+	return dl, nil
+}
+
+// Add appends 'e' to the list of collected entries.
+func (dl *deadLetters) Add(e *DeadLetter) {
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	dl.entries = append(dl.entries, e)
+}
+
+// Write writes all the collected entries to the dead-letter report, replacing any existing report.
+func (dl *deadLetters) Write(ctx context.Context) error {
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	wr, err := dl.bucket.NewWriter(ctx, dl.key, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create dead-letter writer, %w", err)
+	}
+
+	enc := json.NewEncoder(wr)
+
+	for _, e := range dl.entries {
+
+		err := enc.Encode(e)
+
+		if err != nil {
+			wr.Close()
+			return fmt.Errorf("Failed to write dead-letter entry, %w", err)
+		}
+	}
+
+	return wr.Close()
+}
+
+// Read returns an `iter.Seq2[*DeadLetter, error]` for each entry in the (existing) dead-letter report.
+func (dl *deadLetters) Read(ctx context.Context) iter.Seq2[*DeadLetter, error] {
+
+	return func(yield func(e *DeadLetter, err error) bool) {
+
+		r, err := dl.bucket.NewReader(ctx, dl.key, nil)
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to open dead-letter report %s, %w", dl.key, err))
+			return
+		}
+
+		defer r.Close()
+
+		dec := json.NewDecoder(r)
+
+		for {
+
+			var e DeadLetter
+			err := dec.Decode(&e)
+
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to read dead-letter report %s, %w", dl.key, err))
+				return
+			}
+
+			if !yield(&e, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the underlying dead-letter bucket.
+func (dl *deadLetters) Close() error {
+	return dl.bucket.Close()
+}
+
+// handleObjectError applies the iterator's error policy to 'err' which was encountered while processing 'key' and
+// returns a boolean value indicating whether the error should be yielded to the caller.
+func (it *BucketIterator) handleObjectError(key string, err error) bool {
+
+	switch it.on_error {
+	case ON_ERROR_SKIP:
+		it.logger.Warn("Skip object after error", "key", key, "error", err)
+		return false
+	case ON_ERROR_COLLECT:
+		it.logger.Warn("Skip object after error, adding to dead-letter report", "key", key, "error", err)
+		it.dead_letters.Add(newDeadLetter(key, err))
+		return false
+	default:
+		return true
+	}
+}
+
+// deadLetterObjects returns an `iter.Seq2[*blob.ListObject, error]` for each (unique) object in the iterator's
+// dead-letter report contained by 'uri'.
+func (it *BucketIterator) deadLetterObjects(ctx context.Context, uri string) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(obj *blob.ListObject, err error) bool) {
+
+		prefix := listingPrefix(uri)
+		seen := make(map[string]bool)
+
+		for e, err := range it.dead_letters.Read(ctx) {
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if seen[e.Key] || !matchesPrefix(e.Key, prefix) {
+				continue
+			}
+
+			seen[e.Key] = true
+
+			obj := &blob.ListObject{
+				Key: e.Key,
+			}
+
+			if !yield(obj, nil) {
+				return
+			}
+		}
+	}
+}
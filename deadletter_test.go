@@ -0,0 +1,139 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBucketIteratorDeadLetter(t *testing.T) {
+
+	ctx := context.Background()
+
+	data_root := t.TempDir()
+	report_root := t.TempDir()
+
+	abs_path, err := filepath.Abs("fixtures/compressed")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	entries, err := os.ReadDir(abs_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read fixtures, %v", err)
+	}
+
+	for _, e := range entries {
+
+		body, err := os.ReadFile(filepath.Join(abs_path, e.Name()))
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", e.Name(), err)
+		}
+
+		err = os.WriteFile(filepath.Join(data_root, e.Name()), body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", e.Name(), err)
+		}
+	}
+
+	// A file with a ".gz" extension that is not actually gzip-compressed
+
+	err = os.WriteFile(filepath.Join(data_root, "corrupt.geojson.gz"), []byte("not gzip"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write corrupt fixture, %v", err)
+	}
+
+	dead_letter := url.QueryEscape(fmt.Sprintf("file://%s", report_root))
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?decompress=true&on_error=collect&dead_letter=%s", data_root, dead_letter)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Unexpected error, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != len(entries) {
+		t.Fatalf("Expected %d records, but counted %d", len(entries), count)
+	}
+
+	err = it.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close iterator, %v", err)
+	}
+
+	// Re-run using the dead-letter report as the source
+
+	iter_uri = fmt.Sprintf("bucket-file://%s?decompress=true&source=dead_letter&dead_letter=%s", data_root, dead_letter)
+
+	it, err = NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	failed := 0
+
+	for _, err := range it.Iterate(ctx, ".") {
+
+		if err == nil {
+			t.Fatalf("Expected error reading corrupt object")
+		}
+
+		var obj_err *ObjectError
+
+		if !errors.As(err, &obj_err) || obj_err.Op != OP_DECOMPRESS {
+			t.Fatalf("Expected decompress error, got %v", err)
+		}
+
+		failed += 1
+	}
+
+	if failed != 1 {
+		t.Fatalf("Expected 1 failed object, but counted %d", failed)
+	}
+}
+
+func TestBucketIteratorOnErrorInvalid(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	for _, q := range []string{"on_error=bogus", "on_error=collect"} {
+
+		_, err := NewBucketIterator(ctx, fmt.Sprintf("bucket-file://%s?%s", abs_path, q))
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", q)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package bucket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+const (
+	// COMPRESSION_NONE signals that a record is not compressed.
+	COMPRESSION_NONE string = ""
+	// COMPRESSION_GZIP signals that a record is compressed using gzip.
+	COMPRESSION_GZIP string = "gzip"
+	// COMPRESSION_BZIP2 signals that a record is compressed using bzip2.
+	COMPRESSION_BZIP2 string = "bzip2"
+	// COMPRESSION_ZSTD signals that a record is compressed using zstd.
+	COMPRESSION_ZSTD string = "zstd"
+)
+
+var magic_gzip = []byte{0x1f, 0x8b}
+var magic_bzip2 = []byte{0x42, 0x5a, 0x68}
+var magic_zstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// compressionFromExtension returns the compression scheme implied by the file extension of 'key'.
+func compressionFromExtension(key string) string {
+
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".gz", ".gzip", ".tgz":
+		return COMPRESSION_GZIP
+	case ".bz2", ".bzip2", ".tbz2":
+		return COMPRESSION_BZIP2
+	case ".zst", ".zstd", ".tzst":
+		return COMPRESSION_ZSTD
+	default:
+		return COMPRESSION_NONE
+	}
+}
+
+// compressionFromContentEncoding returns the compression scheme implied by the 'Content-Encoding' header value 'enc'.
+func compressionFromContentEncoding(enc string) string {
+
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "gzip", "x-gzip":
+		return COMPRESSION_GZIP
+	case "bzip2", "x-bzip2":
+		return COMPRESSION_BZIP2
+	case "zstd":
+		return COMPRESSION_ZSTD
+	default:
+		return COMPRESSION_NONE
+	}
+}
+
+// compressionFromMagic returns the compression scheme implied by the leading bytes in 'header'.
+func compressionFromMagic(header []byte) string {
+
+	switch {
+	case bytes.HasPrefix(header, magic_gzip):
+		return COMPRESSION_GZIP
+	case bytes.HasPrefix(header, magic_bzip2):
+		return COMPRESSION_BZIP2
+	case bytes.HasPrefix(header, magic_zstd):
+		return COMPRESSION_ZSTD
+	default:
+		return COMPRESSION_NONE
+	}
+}
+
+// sniffCompression returns the compression scheme implied by the leading bytes of 'br' without consuming them.
+func sniffCompression(br *bufio.Reader) string {
+
+	// Errors (including io.EOF for very short objects) simply mean there
+	// aren't enough bytes to match any of the magic numbers.
+	header, _ := br.Peek(len(magic_zstd))
+	return compressionFromMagic(header)
+}
+
+// newDecompressReader returns a new `io.ReadCloser` instance that decompresses 'r' using 'compression'.
+func newDecompressReader(r io.Reader, compression string) (io.ReadCloser, error) {
+
+	switch compression {
+	case COMPRESSION_GZIP:
+		return gzip.NewReader(r)
+	case COMPRESSION_BZIP2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case COMPRESSION_ZSTD:
+
+		zr, err := zstd.NewReader(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("Unsupported compression scheme '%s'", compression)
+	}
+}
+
+// decompressedBody implements the `io.ReadSeekCloser` interface for the body of a record derived from a compressed
+// object, recording the compression scheme that was used.
+type decompressedBody struct {
+	io.ReadSeekCloser
+	// compression is the compression scheme used to decompress the body.
+	compression string
+}
+
+// Compression returns the compression scheme used to decompress the body.
+func (b *decompressedBody) Compression() string {
+	return b.compression
+}
+
+// RecordCompression returns the compression scheme (for example `COMPRESSION_GZIP`) used to decompress the body of 'rec'
+// or `COMPRESSION_NONE` if it was not decompressed. Decompressed records keep the key of the object they were derived
+// from as their `Path` so this is the only way to tell them apart.
+func RecordCompression(rec *iterate.Record) string {
+
+	if rec == nil {
+		return COMPRESSION_NONE
+	}
+
+	b, ok := rec.Body.(interface{ Compression() string })
+
+	if !ok {
+		return COMPRESSION_NONE
+	}
+
+	return b.Compression()
+}
+
+// decompressReadCloser implements the `io.ReadCloser` interface for a decompressed stream closing both the
+// decompressor and the underlying reader.
+type decompressReadCloser struct {
+	io.Reader
+	decompressor io.Closer
+	source       io.Closer
+}
+
+// Close closes both the decompressor and the underlying reader.
+func (r *decompressReadCloser) Close() error {
+
+	err := r.decompressor.Close()
+
+	if err != nil {
+		r.source.Close()
+		return err
+	}
+
+	return r.source.Close()
+}
+
+// openStream opens 'obj' for sequential reading, transparently decompressing it if its file extension or
+// leading bytes indicate that it is compressed.
+func (it *BucketIterator) openStream(ctx context.Context, obj *blob.ListObject) (io.ReadCloser, error) {
+
+	key := obj.Key
+
+	r, err := it.openObject(ctx, obj)
+
+	if err != nil {
+		return nil, newObjectError(OP_GET, key, fmt.Errorf("Failed to open %s for reading, %w", key, err))
+	}
+
+	br := bufio.NewReader(r)
+
+	compression := compressionFromExtension(key)
+
+	if compression == COMPRESSION_NONE {
+		compression = sniffCompression(br)
+	}
+
+	if compression == COMPRESSION_NONE {
+
+		rc := &decompressReadCloser{
+			Reader:       br,
+			decompressor: io.NopCloser(nil),
+			source:       r,
+		}
+
+		return rc, nil
+	}
+
+	dr, err := newDecompressReader(br, compression)
+
+	if err != nil {
+		r.Close()
+		return nil, newObjectError(OP_DECOMPRESS, key, fmt.Errorf("Failed to create %s reader for %s, %w", compression, key, err))
+	}
+
+	rc := &decompressReadCloser{
+		Reader:       dr,
+		decompressor: dr,
+		source:       r,
+	}
+
+	return rc, nil
+}
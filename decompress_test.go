@@ -0,0 +1,141 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+)
+
+func TestBucketIteratorDecompress(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/compressed")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?decompress=true", abs_path)
+
+	it, err := iterate.NewIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	expected := map[string]string{
+		"1360391341.geojson.zst": COMPRESSION_ZSTD,
+		"1360391343.geojson.gz":  COMPRESSION_GZIP,
+		"1360391345.geojson.bz2": COMPRESSION_BZIP2,
+		"1360391347.geojson":     COMPRESSION_GZIP,
+		"1360391349.geojson":     COMPRESSION_NONE,
+	}
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		defer rec.Body.Close()
+
+		compression, ok := expected[rec.Path]
+
+		if !ok {
+			t.Fatalf("Unexpected path '%s'", rec.Path)
+		}
+
+		if RecordCompression(rec) != compression {
+			t.Fatalf("Expected compression for %s to be '%s', but got '%s'", rec.Path, compression, RecordCompression(rec))
+		}
+
+		body, err := io.ReadAll(rec.Body)
+
+		if err != nil {
+			t.Fatalf("Failed to read body for %s, %v", rec.Path, err)
+		}
+
+		if !json.Valid(body) {
+			t.Fatalf("Body for %s is not valid JSON", rec.Path)
+		}
+
+		count += 1
+	}
+
+	if count != len(expected) {
+		t.Fatalf("Expected %d records, but counted %d", len(expected), count)
+	}
+}
+
+func TestBucketIteratorDecompressIteratorParameters(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/compressed")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// The "_"-prefixed parameters are applied by the whosonfirst/go-whosonfirst-iterate/v3 package which
+	// wraps the bucket iterator and parses record paths as Who's On First URIs when deduping. The path of
+	// the gzip-compressed "1360391347.geojson" object must remain a valid Who's On First URI.
+
+	q := url.Values{}
+	q.Set("decompress", "true")
+	q.Set("_dedupe", "true")
+	q.Set("_include", `\.geojson$`)
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?%s", abs_path, q.Encode())
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	expected := map[string]string{
+		"1360391347.geojson": COMPRESSION_GZIP,
+		"1360391349.geojson": COMPRESSION_NONE,
+	}
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+
+		compression, ok := expected[rec.Path]
+
+		if !ok {
+			t.Fatalf("Unexpected path '%s'", rec.Path)
+		}
+
+		if RecordCompression(rec) != compression {
+			t.Fatalf("Expected compression for %s to be '%s', but got '%s'", rec.Path, compression, RecordCompression(rec))
+		}
+
+		count += 1
+	}
+
+	if count != len(expected) {
+		t.Fatalf("Expected %d records, but counted %d", len(expected), count)
+	}
+}
@@ -0,0 +1,67 @@
+package bucket
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+// DryRunMetadata is the body of the records yielded when the iterator is in dry-run mode.
+type DryRunMetadata struct {
+	// Key is the key of the object.
+	Key string `json:"key"`
+	// Size is the size of the object in bytes, if known.
+	Size int64 `json:"size,omitempty"`
+	// ModTime is the time the object was last modified, if known.
+	ModTime *time.Time `json:"modtime,omitempty"`
+	// MD5 is the hex-encoded MD5 checksum of the object, if known.
+	MD5 string `json:"md5,omitempty"`
+}
+
+// dryRunRecords returns an `iter.Seq2[*Record, error]` for a single record whose body is the JSON-encoded `DryRunMetadata`
+// for 'obj', derived from the listing alone, unless it is excluded by the iterator's filters.
+func (it *BucketIterator) dryRunRecords(ctx context.Context, obj *blob.ListObject) iter.Seq2[*iterate.Record, error] {
+
+	return func(yield func(rec *iterate.Record, err error) bool) {
+
+		md := DryRunMetadata{
+			Key:  obj.Key,
+			Size: obj.Size,
+		}
+
+		if !obj.ModTime.IsZero() {
+			md.ModTime = &obj.ModTime
+		}
+
+		if len(obj.MD5) > 0 {
+			md.MD5 = hex.EncodeToString(obj.MD5)
+		}
+
+		body, err := json.Marshal(md)
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to marshal metadata for %s, %w", obj.Key, err))
+			return
+		}
+
+		// The iterator's (content) filters are applied to the metadata, rather than the object, so they can
+		// include or exclude objects by their key, size, modification time or checksum.
+
+		rec, err := it.bytesRecord(ctx, obj.Key, body)
+
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		if rec != nil {
+			yield(rec, nil)
+		}
+	}
+}
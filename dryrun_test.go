@@ -0,0 +1,142 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBucketIteratorDryRun(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	tests := map[string]int{
+		"dry_run=true": 37,
+		fmt.Sprintf("dry_run=true&_include=%s", url.QueryEscape("^174/")): 3,
+	}
+
+	for q, expected := range tests {
+
+		iter_uri := fmt.Sprintf("bucket-file://%s?%s", abs_path, q)
+
+		iter, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator for '%s', %v", q, err)
+		}
+
+		it := iter.(*BucketIterator)
+		count := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket for '%s', %v", q, err)
+			}
+
+			body, err := io.ReadAll(rec.Body)
+			rec.Body.Close()
+
+			if err != nil {
+				t.Fatalf("Failed to read %s, %v", rec.Path, err)
+			}
+
+			var md DryRunMetadata
+
+			err = json.Unmarshal(body, &md)
+
+			if err != nil {
+				t.Fatalf("Failed to unmarshal metadata for %s, %v", rec.Path, err)
+			}
+
+			if md.Key != rec.Path || !strings.HasSuffix(md.Key, ".geojson") || md.Size == 0 || md.ModTime == nil {
+				t.Fatalf("Unexpected metadata for %s, %s", rec.Path, string(body))
+			}
+
+			count += 1
+		}
+
+		st := it.Stats()
+		it.Close()
+
+		if count != expected {
+			t.Fatalf("Expected %d records for '%s', but counted %d", expected, q, count)
+		}
+
+		if st.GetCalls != 0 || st.ObjectsOpened != 0 {
+			t.Fatalf("Expected no objects to be opened for '%s', %v", q, st)
+		}
+	}
+}
+
+func TestBucketIteratorDryRunContentFilters(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// Content filters are applied to the listing metadata rather than the object
+
+	tests := map[string]int{
+		fmt.Sprintf("include=%s", url.QueryEscape("key=^174/")):         3,
+		fmt.Sprintf("exclude=%s", url.QueryEscape("key=^174/")):         34,
+		fmt.Sprintf("include=%s", url.QueryEscape("size=^[0-9]{1,2}$")): 0,
+		fmt.Sprintf("include=%s", url.QueryEscape("size=^[0-9]{3,}$")):  37,
+	}
+
+	for q, expected := range tests {
+
+		iter_uri := fmt.Sprintf("bucket-file://%s?dry_run=true&%s", abs_path, q)
+
+		iter, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator for '%s', %v", q, err)
+		}
+
+		it := iter.(*BucketIterator)
+		count := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket for '%s', %v", q, err)
+			}
+
+			rec.Body.Close()
+			count += 1
+		}
+
+		st := it.Stats()
+		it.Close()
+
+		if count != expected {
+			t.Fatalf("Expected %d records for '%s', but counted %d", expected, q, count)
+		}
+
+		filtered := st.RecordsFiltered[FILTER_INCLUDE] + st.RecordsFiltered[FILTER_EXCLUDE]
+
+		if filtered != int64(37-expected) {
+			t.Fatalf("Expected %d records to be filtered for '%s', but got %d", 37-expected, q, filtered)
+		}
+
+		if st.GetCalls != 0 || st.ObjectsOpened != 0 {
+			t.Fatalf("Expected no objects to be opened for '%s', %v", q, st)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// fakeError is an error with a specific `gocloud.dev/gcerrors` code returned by `fakeBucket`.
+type fakeError struct {
+	code gcerrors.ErrorCode
+}
+
+// Error returns the string representation of 'e'.
+func (e *fakeError) Error() string {
+	return "fake error: " + e.code.String()
+}
+
+// fakeBucket implements the `gocloud.dev/blob/driver.Bucket` interface for a set of objects stored in memory. Errors
+// can be injected in to listing and read requests and it records the number of requests made, the maximum number of
+// concurrent read requests (including the time spent reading their bodies) and whether it was closed.
+type fakeBucket struct {
+	mu *sync.Mutex
+	// objects are the bodies of the objects in the bucket keyed by their key.
+	objects map[string][]byte
+	// list_errors are the errors returned, in order, by the next calls to `ListPaged`. Nil values are not errors.
+	list_errors []error
+	// get_errors are the errors returned, in order, by the next calls to `NewRangeReader`. Nil values are not errors.
+	get_errors []error
+	// get_error is called with the number of concurrent read requests (not including the time spent reading their
+	// bodies) and returns an error for the request, or nil. It is consulted after 'get_errors'.
+	get_error func(requests int) error
+	// latency is the amount of time each read request takes.
+	latency    time.Duration
+	list_calls int
+	get_calls  int
+	// requests is the number of read requests in progress.
+	requests int
+	// in_flight is the number of read requests whose bodies have not been closed.
+	in_flight int
+	// max_in_flight is the maximum value of 'in_flight'.
+	max_in_flight int
+	closed        bool
+}
+
+// newFakeBucket returns a new `fakeBucket` instance containing 'objects'.
+func newFakeBucket(objects map[string]string) *fakeBucket {
+
+	b := &fakeBucket{
+		mu:      new(sync.Mutex),
+		objects: make(map[string][]byte),
+	}
+
+	for k, v := range objects {
+		b.objects[k] = []byte(v)
+	}
+
+	return b
+}
+
+// Closed returns a boolean value indicating whether 'b' was closed.
+func (b *fakeBucket) Closed() bool {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.closed
+}
+
+// Calls returns the number of listing and read requests made to 'b'.
+func (b *fakeBucket) Calls() (int, int) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.list_calls, b.get_calls
+}
+
+// MaxInFlight returns the maximum number of concurrent read requests made to 'b'.
+func (b *fakeBucket) MaxInFlight() int {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.max_in_flight
+}
+
+func (b *fakeBucket) ErrorCode(err error) gcerrors.ErrorCode {
+
+	var fe *fakeError
+
+	if errors.As(err, &fe) {
+		return fe.code
+	}
+
+	return gcerrors.Unknown
+}
+
+func (b *fakeBucket) As(i any) bool {
+	return false
+}
+
+func (b *fakeBucket) ErrorAs(err error, i any) bool {
+	return false
+}
+
+func (b *fakeBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	body, ok := b.objects[key]
+
+	if !ok {
+		return nil, &fakeError{code: gcerrors.NotFound}
+	}
+
+	attrs := &driver.Attributes{
+		Size:    int64(len(body)),
+		ModTime: time.Unix(0, 0),
+	}
+
+	return attrs, nil
+}
+
+func (b *fakeBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.list_calls += 1
+
+	if len(b.list_errors) > 0 {
+
+		err := b.list_errors[0]
+		b.list_errors = b.list_errors[1:]
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0)
+	dirs := make(map[string]bool)
+
+	for k := range b.objects {
+
+		if !strings.HasPrefix(k, opts.Prefix) {
+			continue
+		}
+
+		if opts.Delimiter != "" {
+
+			rel := strings.TrimPrefix(k, opts.Prefix)
+			idx := strings.Index(rel, opts.Delimiter)
+
+			if idx > -1 {
+				dir := opts.Prefix + rel[0:idx+len(opts.Delimiter)]
+
+				if !dirs[dir] {
+					dirs[dir] = true
+					keys = append(keys, dir)
+				}
+
+				continue
+			}
+		}
+
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	offset := 0
+
+	if len(opts.PageToken) > 0 {
+
+		v, err := strconv.Atoi(string(opts.PageToken))
+
+		if err != nil {
+			return nil, &fakeError{code: gcerrors.InvalidArgument}
+		}
+
+		offset = v
+	}
+
+	page_size := opts.PageSize
+
+	if page_size == 0 {
+		page_size = 1000
+	}
+
+	page := &driver.ListPage{}
+
+	for i := offset; i < len(keys) && i < offset+page_size; i++ {
+
+		k := keys[i]
+
+		obj := &driver.ListObject{
+			Key:   k,
+			IsDir: dirs[k],
+		}
+
+		if !obj.IsDir {
+			obj.Size = int64(len(b.objects[k]))
+			obj.ModTime = time.Unix(0, 0)
+		}
+
+		page.Objects = append(page.Objects, obj)
+	}
+
+	if offset+page_size < len(keys) {
+		page.NextPageToken = []byte(strconv.Itoa(offset + page_size))
+	}
+
+	return page, nil
+}
+
+func (b *fakeBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+
+	b.mu.Lock()
+
+	b.get_calls += 1
+	b.requests += 1
+	b.in_flight += 1
+	b.max_in_flight = max(b.max_in_flight, b.in_flight)
+
+	var err error
+
+	if len(b.get_errors) > 0 {
+		err = b.get_errors[0]
+		b.get_errors = b.get_errors[1:]
+	} else if b.get_error != nil {
+		err = b.get_error(b.requests)
+	}
+
+	body, ok := b.objects[key]
+
+	b.mu.Unlock()
+
+	if b.latency > 0 {
+		time.Sleep(b.latency)
+	}
+
+	b.mu.Lock()
+	b.requests -= 1
+	b.mu.Unlock()
+
+	if err == nil && !ok {
+		err = &fakeError{code: gcerrors.NotFound}
+	}
+
+	if err != nil {
+		b.release()
+		return nil, err
+	}
+
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+
+	body = body[offset:]
+
+	if length >= 0 && length < int64(len(body)) {
+		body = body[:length]
+	}
+
+	r := &fakeReader{
+		Reader: bytes.NewReader(body),
+		bucket: b,
+		once:   new(sync.Once),
+		attrs: &driver.ReaderAttributes{
+			Size:    int64(len(b.objects[key])),
+			ModTime: time.Unix(0, 0),
+		},
+	}
+
+	return r, nil
+}
+
+// release records that a read request finished.
+func (b *fakeBucket) release() {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.in_flight -= 1
+}
+
+func (b *fakeBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+
+	w := &fakeWriter{
+		bucket: b,
+		key:    key,
+	}
+
+	return w, nil
+}
+
+func (b *fakeBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	return &fakeError{code: gcerrors.Unimplemented}
+}
+
+func (b *fakeBucket) Delete(ctx context.Context, key string) error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.objects[key]
+
+	if !ok {
+		return &fakeError{code: gcerrors.NotFound}
+	}
+
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *fakeBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", &fakeError{code: gcerrors.Unimplemented}
+}
+
+func (b *fakeBucket) Close() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+// fakeReader implements the `gocloud.dev/blob/driver.Reader` interface for an object in a `fakeBucket`.
+type fakeReader struct {
+	io.Reader
+	bucket *fakeBucket
+	once   *sync.Once
+	attrs  *driver.ReaderAttributes
+}
+
+func (r *fakeReader) Close() error {
+	r.once.Do(r.bucket.release)
+	return nil
+}
+
+func (r *fakeReader) Attributes() *driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r *fakeReader) As(i any) bool {
+	return false
+}
+
+// fakeWriter implements the `gocloud.dev/blob/driver.Writer` interface for an object in a `fakeBucket`.
+type fakeWriter struct {
+	bucket *fakeBucket
+	key    string
+	buf    bytes.Buffer
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *fakeWriter) Close() error {
+
+	w.bucket.mu.Lock()
+	defer w.bucket.mu.Unlock()
+
+	w.bucket.objects[w.key] = w.buf.Bytes()
+	return nil
+}
+
+// fakeURLOpener implements the `gocloud.dev/blob.BucketURLOpener` interface returning a new `blob.Bucket` instance
+// for 'bucket' each time it is called.
+type fakeURLOpener struct {
+	bucket *fakeBucket
+}
+
+func (o *fakeURLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	return blob.NewBucket(o.bucket), nil
+}
@@ -0,0 +1,162 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"path/filepath"
+	"strings"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+// isFeatureCollectionObject returns a boolean value indicating whether 'key', ignoring any compression extension, may contain a GeoJSON FeatureCollection.
+func isFeatureCollectionObject(key string) bool {
+
+	key = strings.ToLower(key)
+
+	if compressionFromExtension(key) != COMPRESSION_NONE {
+		key = strings.TrimSuffix(key, filepath.Ext(key))
+	}
+
+	switch filepath.Ext(key) {
+	case ".geojson", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// featureCollectionPath returns the record path for the feature at (zero-based) 'index' in 'key'.
+func featureCollectionPath(key string, index int) string {
+	return fmt.Sprintf("%s#%d", key, index)
+}
+
+// featureCollectionRecords returns an `iter.Seq2[*Record, error]` for each feature contained by the GeoJSON FeatureCollection object 'obj'.
+// The object is read using a token-level JSON decoder so that only one feature at a time is held in memory.
+func (it *BucketIterator) featureCollectionRecords(ctx context.Context, obj *blob.ListObject) iter.Seq2[*iterate.Record, error] {
+
+	return func(yield func(rec *iterate.Record, err error) bool) {
+
+		if !isFeatureCollectionObject(obj.Key) {
+			it.logger.Debug("Skip non-FeatureCollection object", "key", obj.Key)
+			it.hooks.skip(ctx, obj.Key, SKIP_MODE)
+			return
+		}
+
+		r, err := it.openStream(ctx, obj)
+
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		defer r.Close()
+
+		dec := json.NewDecoder(r)
+
+		err = expectDelim(dec, '{')
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to read %s as a JSON object, %w", obj.Key, err))
+			return
+		}
+
+		for dec.More() {
+
+			t, err := dec.Token()
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to read next property in %s, %w", obj.Key, err))
+				return
+			}
+
+			if t != "features" {
+
+				var v json.RawMessage
+				err := dec.Decode(&v)
+
+				if err != nil {
+					yield(nil, fmt.Errorf("Failed to read '%v' property in %s, %w", t, obj.Key, err))
+					return
+				}
+
+				continue
+			}
+
+			err = expectDelim(dec, '[')
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to read features in %s, %w", obj.Key, err))
+				return
+			}
+
+			i := 0
+
+			for dec.More() {
+
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					// pass
+				}
+
+				path := featureCollectionPath(obj.Key, i)
+				i += 1
+
+				var feature json.RawMessage
+				err := dec.Decode(&feature)
+
+				if err != nil {
+					yield(nil, fmt.Errorf("Failed to decode feature at '%s', %w", path, err))
+					return
+				}
+
+				rec, err := it.bytesRecord(ctx, path, feature)
+
+				if err != nil {
+
+					if !yield(nil, err) {
+						return
+					}
+
+					continue
+				}
+
+				if rec == nil {
+					continue
+				}
+
+				if !yield(rec, nil) {
+					return
+				}
+			}
+
+			err = expectDelim(dec, ']')
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to read features in %s, %w", obj.Key, err))
+				return
+			}
+		}
+	}
+}
+
+// expectDelim reads the next token from 'dec' and returns an error if it is not 'delim'.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+
+	t, err := dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if t != delim {
+		return fmt.Errorf("Expected '%s' but found '%v'", delim, t)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+)
+
+func TestBucketIteratorFeatureCollection(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/featurecollection")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?mode=featurecollection", abs_path)
+
+	it, err := iterate.NewIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	expected := map[string]bool{
+		"features.geojson#0": true,
+		"features.geojson#1": true,
+		"features.geojson#2": true,
+		"features.geojson#3": true,
+		"features.json.gz#0": true,
+		"features.json.gz#1": true,
+	}
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		defer rec.Body.Close()
+
+		_, ok := expected[rec.Path]
+
+		if !ok {
+			t.Fatalf("Unexpected path '%s'", rec.Path)
+		}
+
+		var f struct {
+			Type string `json:"type"`
+		}
+
+		dec := json.NewDecoder(rec.Body)
+		err = dec.Decode(&f)
+
+		if err != nil {
+			t.Fatalf("Failed to decode %s, %v", rec.Path, err)
+		}
+
+		if f.Type != "Feature" {
+			t.Fatalf("Unexpected type '%s' for %s", f.Type, rec.Path)
+		}
+
+		_, err = rec.Body.Seek(0, io.SeekStart)
+
+		if err != nil {
+			t.Fatalf("Failed to rewind body for %s, %v", rec.Path, err)
+		}
+
+		count += 1
+	}
+
+	if count != len(expected) {
+		t.Fatalf("Expected %d records, but counted %d", len(expected), count)
+	}
+}
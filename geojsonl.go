@@ -0,0 +1,115 @@
+package bucket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"path/filepath"
+	"strings"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+// isGeoJSONLObject returns a boolean value indicating whether 'key', ignoring any compression extension, is a line-delimited GeoJSON file.
+func isGeoJSONLObject(key string) bool {
+
+	key = strings.ToLower(key)
+
+	if compressionFromExtension(key) != COMPRESSION_NONE {
+		key = strings.TrimSuffix(key, filepath.Ext(key))
+	}
+
+	return strings.HasSuffix(key, ".geojsonl")
+}
+
+// geojsonlPath returns the record path for line number 'line' in 'key'.
+func geojsonlPath(key string, line int) string {
+	return fmt.Sprintf("%s#L%d", key, line)
+}
+
+// geojsonlRecords returns an `iter.Seq2[*Record, error]` for each feature contained by the line-delimited GeoJSON object 'obj'.
+// Lines which can not be parsed as JSON yield an error for that line but do not stop iteration of the remaining lines.
+func (it *BucketIterator) geojsonlRecords(ctx context.Context, obj *blob.ListObject) iter.Seq2[*iterate.Record, error] {
+
+	return func(yield func(rec *iterate.Record, err error) bool) {
+
+		if !isGeoJSONLObject(obj.Key) {
+			it.logger.Debug("Skip non-GeoJSONL object", "key", obj.Key)
+			it.hooks.skip(ctx, obj.Key, SKIP_MODE)
+			return
+		}
+
+		r, err := it.openStream(ctx, obj)
+
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		defer r.Close()
+
+		// Use ReadBytes rather than bufio.Scanner since it is entirely possible
+		// that a single (GeoJSON) line will be longer than bufio.MaxScanTokenSize.
+
+		reader := bufio.NewReader(r)
+		line := 0
+
+		for {
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				// pass
+			}
+
+			raw, read_err := reader.ReadBytes('\n')
+
+			if read_err != nil && read_err != io.EOF {
+				yield(nil, fmt.Errorf("Failed to read line %d of %s, %w", line+1, obj.Key, read_err))
+				return
+			}
+
+			line += 1
+			raw = bytes.TrimSpace(raw)
+
+			if len(raw) > 0 {
+
+				path := geojsonlPath(obj.Key, line)
+
+				if !json.Valid(raw) {
+
+					if !yield(nil, newRecordError(path, fmt.Errorf("Invalid JSON at '%s'", path))) {
+						return
+					}
+
+				} else {
+
+					rec, err := it.bytesRecord(ctx, path, raw)
+
+					if err != nil {
+
+						if !yield(nil, newRecordError(path, err)) {
+							return
+						}
+
+					} else if rec != nil {
+
+						if !yield(rec, nil) {
+							return
+						}
+					}
+				}
+			}
+
+			if read_err == io.EOF {
+				return
+			}
+		}
+	}
+}
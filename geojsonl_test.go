@@ -0,0 +1,71 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBucketIteratorGeoJSONL(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/geojsonl")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// Invalid lines yield an error but, with the default error policy, do not stop the remaining
+	// lines (or objects) from being iterated.
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?mode=geojsonl", abs_path)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	expected := map[string]bool{
+		"features.geojsonl#L1":    true,
+		"features.geojsonl#L3":    true,
+		"features.geojsonl#L5":    true,
+		"features.geojsonl.gz#L1": true,
+		"features.geojsonl.gz#L2": true,
+		"features.geojsonl.gz#L3": true,
+		"features.geojsonl.gz#L4": true,
+	}
+
+	count := 0
+	errors := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			errors += 1
+			continue
+		}
+
+		defer rec.Body.Close()
+
+		_, ok := expected[rec.Path]
+
+		if !ok {
+			t.Fatalf("Unexpected path '%s'", rec.Path)
+		}
+
+		count += 1
+	}
+
+	if count != len(expected) {
+		t.Fatalf("Expected %d records, but counted %d", len(expected), count)
+	}
+
+	if errors != 1 {
+		t.Fatalf("Expected 1 error, but counted %d", errors)
+	}
+}
@@ -0,0 +1,184 @@
+package bucket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+// SKIP_VETOED is the reason passed to `Hooks.OnSkip` when an object is vetoed by `Hooks.OnOpen`.
+const SKIP_VETOED string = "vetoed"
+
+// SKIP_RESUMED is the reason passed to `Hooks.OnSkip` when an object is skipped because it was processed in full during a previous (failed) attempt to iterate the same URI.
+const SKIP_RESUMED string = "resumed"
+
+// SKIP_MODE is the reason passed to `Hooks.OnSkip` when an object is skipped because it can not be processed by the iterator's mode.
+const SKIP_MODE string = "mode"
+
+// SKIP_ERROR is the reason passed to `Hooks.OnSkip` when an object is skipped after an error because of the iterator's error policy.
+const SKIP_ERROR string = "error"
+
+// Hooks defines optional callbacks invoked at different points in the lifecycle of a `BucketIterator`. Any of the
+// callbacks may be nil.
+//
+// Hooks are invoked synchronously, on the goroutine iterating a URI, and iteration does not continue until they return
+// so they should be fast. Hooks for a single URI are never invoked concurrently and are invoked in order but the
+// `whosonfirst/go-whosonfirst-iterate/v3` package iterates multiple URIs concurrently, so hooks may be invoked
+// concurrently for different URIs and must be safe for concurrent use.
+//
+// If adaptive concurrency is enabled objects are listed, and fetched, ahead of the caller. In that case `OnList` and
+// `OnOpen` are invoked when an object is scheduled to be fetched, which may be before the records for objects listed
+// earlier are yielded, and hooks for work done in other goroutines are replayed, in order, on the goroutine iterating
+// the URI once the results of that work are reached.
+type Hooks struct {
+	// OnList is invoked with each page of objects retrieved by listing 'prefix' in the bucket. It is not invoked for
+	// listings read from the listing cache or for objects derived from "meta" files or dead-letter reports.
+	OnList func(ctx context.Context, prefix string, page []*blob.ListObject)
+	// OnOpen is invoked before each object is opened with the attributes reported by its listing. If it returns false
+	// the object is not opened and `OnSkip` is invoked with the `SKIP_VETOED` reason.
+	OnOpen func(ctx context.Context, key string, attrs *blob.ListObject) bool
+	// OnYield is invoked with each record once it has been yielded to the caller. Records excluded by the
+	// `whosonfirst/go-whosonfirst-iterate/v3` concurrent iterator (for example using the `_exclude` or `_dedupe`
+	// parameters) are reported to `OnSkip` instead. The record's body must not be read since the caller may be reading it.
+	OnYield func(ctx context.Context, rec *iterate.Record)
+	// OnSkip is invoked when an object, or a record derived from one, is skipped. 'key' is the object's key or, for
+	// records excluded by filters, the record's path. 'reason' is one of `SKIP_VETOED`, `SKIP_RESUMED`, `SKIP_MODE`,
+	// `SKIP_ERROR` or, for records excluded by filters, the name of the filter (`FILTER_INCLUDE`, `FILTER_EXCLUDE`,
+	// `FILTER_OTHER` or `FILTER_ITERATOR`).
+	OnSkip func(ctx context.Context, key string, reason string)
+	// OnError is invoked with each error encountered processing the object 'key', before the iterator's error policy is
+	// applied. If the objects for a URI can not be derived (for example if listing the bucket fails) 'key' is empty.
+	OnError func(ctx context.Context, key string, err error)
+}
+
+// SetHooks replaces the iterator's lifecycle hooks. It should be called before iteration starts.
+func (it *BucketIterator) SetHooks(h *Hooks) {
+	it.hooks = h
+}
+
+// deferredHooksKey is the context key used to store the `deferredHooks` instance for work done in a goroutine other than
+// the one iterating a URI.
+type deferredHooksKey struct{}
+
+// deferredHooks records the hooks invoked by work done in a goroutine other than the one iterating a URI so that they can
+// be replayed on that goroutine. Note that `Hooks.OnOpen` returns a value so it is never deferred.
+type deferredHooks struct {
+	mu    *sync.Mutex
+	calls []func()
+}
+
+// withDeferredHooks returns a copy of 'ctx', and a new `deferredHooks` instance which it carries, such that the hooks
+// invoked with that context are recorded rather than invoked.
+func withDeferredHooks(ctx context.Context) (context.Context, *deferredHooks) {
+
+	d := &deferredHooks{
+		mu:    new(sync.Mutex),
+		calls: make([]func(), 0),
+	}
+
+	return context.WithValue(ctx, deferredHooksKey{}, d), d
+}
+
+// deferHook records 'fn' if 'ctx' carries a `deferredHooks` instance and returns a boolean value indicating whether it did.
+func deferHook(ctx context.Context, fn func()) bool {
+
+	d, ok := ctx.Value(deferredHooksKey{}).(*deferredHooks)
+
+	if !ok {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.calls = append(d.calls, fn)
+	return true
+}
+
+// Drain removes, and returns, the hooks recorded by 'd' so far.
+func (d *deferredHooks) Drain() []func() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	calls := d.calls
+	d.calls = make([]func(), 0)
+
+	return calls
+}
+
+// replayHooks invokes each of 'calls' in order.
+func replayHooks(calls []func()) {
+
+	for _, fn := range calls {
+		fn()
+	}
+}
+
+// list invokes 'h.OnList' if it is defined.
+func (h *Hooks) list(ctx context.Context, prefix string, page []*blob.ListObject) {
+
+	if h == nil || h.OnList == nil {
+		return
+	}
+
+	if deferHook(ctx, func() { h.OnList(ctx, prefix, page) }) {
+		return
+	}
+
+	h.OnList(ctx, prefix, page)
+}
+
+// open invokes 'h.OnOpen', if it is defined, and returns its result. If it is not defined it returns true.
+func (h *Hooks) open(ctx context.Context, obj *blob.ListObject) bool {
+
+	if h == nil || h.OnOpen == nil {
+		return true
+	}
+
+	return h.OnOpen(ctx, obj.Key, obj)
+}
+
+// yield invokes 'h.OnYield' if it is defined.
+func (h *Hooks) yield(ctx context.Context, rec *iterate.Record) {
+
+	if h == nil || h.OnYield == nil {
+		return
+	}
+
+	if deferHook(ctx, func() { h.OnYield(ctx, rec) }) {
+		return
+	}
+
+	h.OnYield(ctx, rec)
+}
+
+// skip invokes 'h.OnSkip' if it is defined.
+func (h *Hooks) skip(ctx context.Context, key string, reason string) {
+
+	if h == nil || h.OnSkip == nil {
+		return
+	}
+
+	if deferHook(ctx, func() { h.OnSkip(ctx, key, reason) }) {
+		return
+	}
+
+	h.OnSkip(ctx, key, reason)
+}
+
+// error invokes 'h.OnError' if it is defined.
+func (h *Hooks) error(ctx context.Context, key string, err error) {
+
+	if h == nil || h.OnError == nil {
+		return
+	}
+
+	if deferHook(ctx, func() { h.OnError(ctx, key, err) }) {
+		return
+	}
+
+	h.OnError(ctx, key, err)
+}
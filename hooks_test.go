@@ -0,0 +1,287 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+func TestBucketIteratorHooks(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	mu := new(sync.Mutex)
+
+	listed := 0
+	opened := 0
+	yielded := 0
+	skipped := map[string]int{}
+
+	hooks := &Hooks{
+		OnList: func(ctx context.Context, prefix string, page []*blob.ListObject) {
+			mu.Lock()
+			defer mu.Unlock()
+			listed += len(page)
+		},
+		OnOpen: func(ctx context.Context, key string, attrs *blob.ListObject) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			opened += 1
+			return !strings.HasPrefix(key, "174/")
+		},
+		OnYield: func(ctx context.Context, rec *iterate.Record) {
+			mu.Lock()
+			defer mu.Unlock()
+			yielded += 1
+		},
+		OnSkip: func(ctx context.Context, key string, reason string) {
+			mu.Lock()
+			defer mu.Unlock()
+			skipped[reason] += 1
+		},
+	}
+
+	// Records whose paths start with "147/" are excluded by the concurrent iterator which wraps the bucket iterator
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?include=properties.mz:is_current=1&_exclude=%s", abs_path, url.QueryEscape("^147/"))
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	it.(*BucketIterator).SetHooks(hooks)
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		if strings.HasPrefix(rec.Path, "174/") {
+			t.Fatalf("Vetoed object was yielded: %s", rec.Path)
+		}
+
+		if strings.HasPrefix(rec.Path, "147/") {
+			t.Fatalf("Excluded object was yielded: %s", rec.Path)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if listed < 37 {
+		t.Fatalf("Unexpected number of listed objects: %d", listed)
+	}
+
+	if opened != 37 {
+		t.Fatalf("Unexpected number of open hooks: %d", opened)
+	}
+
+	if skipped[SKIP_VETOED] != 3 {
+		t.Fatalf("Unexpected number of vetoed objects: %d", skipped[SKIP_VETOED])
+	}
+
+	if yielded != count {
+		t.Fatalf("Unexpected number of yield hooks: %d (expected %d)", yielded, count)
+	}
+
+	if skipped[FILTER_ITERATOR] == 0 {
+		t.Fatalf("Expected records excluded by the concurrent iterator to be skipped: %v", skipped)
+	}
+
+	if skipped[FILTER_INCLUDE] == 0 || count+skipped[FILTER_INCLUDE]+skipped[FILTER_ITERATOR]+skipped[SKIP_VETOED] != 37 {
+		t.Fatalf("Unexpected skip reasons: %v (yielded %d)", skipped, count)
+	}
+
+	st := it.(*BucketIterator).Stats()
+
+	if st.ObjectsOpened != 34 {
+		t.Fatalf("Expected vetoed objects not to be opened, opened %d", st.ObjectsOpened)
+	}
+
+	if st.RecordsFiltered[FILTER_ITERATOR] != int64(skipped[FILTER_ITERATOR]) {
+		t.Fatalf("Expected %d records to be filtered by the concurrent iterator, but counted %d", skipped[FILTER_ITERATOR], st.RecordsFiltered[FILTER_ITERATOR])
+	}
+}
+
+func TestBucketIteratorErrorHooks(t *testing.T) {
+
+	ctx := context.Background()
+
+	data_root := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(data_root, "corrupt.geojson.gz"), []byte("not gzip"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write corrupt fixture, %v", err)
+	}
+
+	errs := map[string]int{}
+	skipped := map[string]string{}
+
+	opts := DefaultBucketIteratorOptions()
+	opts.Decompress = true
+	opts.OnError = ON_ERROR_SKIP
+	opts.WithStats = false
+
+	opts.Hooks = &Hooks{
+		OnError: func(ctx context.Context, key string, err error) {
+			errs[key] += 1
+		},
+		OnSkip: func(ctx context.Context, key string, reason string) {
+			skipped[key] = reason
+		},
+	}
+
+	b, err := blob.OpenBucket(ctx, fmt.Sprintf("file://%s", data_root))
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer b.Close()
+
+	it, err := NewBucketIteratorWithBucket(ctx, b, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	for _, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Expected errors to be skipped, %v", err)
+		}
+	}
+
+	if errs["corrupt.geojson.gz"] != 1 {
+		t.Fatalf("Expected error hook to be invoked, %v", errs)
+	}
+
+	if skipped["corrupt.geojson.gz"] != SKIP_ERROR {
+		t.Fatalf("Expected skip hook to be invoked, %v", skipped)
+	}
+}
+
+func TestBucketIteratorHooksOrder(t *testing.T) {
+
+	ctx := context.Background()
+
+	objects := make(map[string]string)
+	keys := make([]string, 0)
+
+	for i := 0; i < 4; i++ {
+
+		for j := 0; j < 12; j++ {
+			k := fmt.Sprintf("%02d/%03d.geojson", i, j)
+			objects[k] = fmt.Sprintf(`{"type": "Feature", "properties": {"n": %d}}`, j%2)
+			keys = append(keys, k)
+		}
+	}
+
+	fb := newFakeBucket(objects)
+	fb.latency = 1 * time.Millisecond
+
+	q := url.Values{}
+	q.Set("include", "properties.n=1")
+
+	opts, err := NewBucketIteratorOptionsFromQuery(ctx, q)
+
+	if err != nil {
+		t.Fatalf("Failed to derive options, %v", err)
+	}
+
+	opts.AdaptiveConcurrency = true
+	opts.MaxGetConcurrency = 8
+	opts.MaxListConcurrency = 4
+
+	iter, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	// Hooks for a single URI are never invoked concurrently, even though objects are listed and fetched by
+	// multiple goroutines, so these are deliberately not guarded by a mutex (run with -race to check)
+
+	listed := make([]string, 0)
+	opened := make([]string, 0)
+	events := make([]string, 0)
+
+	hooks := &Hooks{
+		OnList: func(ctx context.Context, prefix string, page []*blob.ListObject) {
+
+			for _, obj := range page {
+				listed = append(listed, obj.Key)
+			}
+		},
+		OnOpen: func(ctx context.Context, key string, attrs *blob.ListObject) bool {
+			opened = append(opened, key)
+			return true
+		},
+		OnYield: func(ctx context.Context, rec *iterate.Record) {
+			events = append(events, rec.Path)
+		},
+		OnSkip: func(ctx context.Context, key string, reason string) {
+			events = append(events, key)
+		},
+	}
+
+	iter.(*BucketIterator).SetHooks(hooks)
+
+	count := 0
+
+	for rec, err := range iter.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != len(keys)/2 {
+		t.Fatalf("Expected %d records, but counted %d", len(keys)/2, count)
+	}
+
+	if !slices.Equal(listed, keys) {
+		t.Fatalf("Expected objects to be listed in order, %v", listed)
+	}
+
+	if !slices.Equal(opened, keys) {
+		t.Fatalf("Expected objects to be opened in listing order, %v", opened)
+	}
+
+	// Records which were yielded and records which were excluded by the 'include' filter are reported in listing order
+
+	if !slices.Equal(events, keys) {
+		t.Fatalf("Expected yield and skip hooks in listing order, %v", events)
+	}
+}
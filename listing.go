@@ -0,0 +1,336 @@
+package bucket
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// DEFAULT_LISTING_CACHE_TTL is the default amount of time a cached bucket listing is considered fresh.
+const DEFAULT_LISTING_CACHE_TTL time.Duration = 1 * time.Hour
+
+// listingHeader is the first line of a cached bucket listing.
+type listingHeader struct {
+	// Prefix is the listing prefix the cached listing was derived from.
+	Prefix string `json:"prefix"`
+	// Created is the Unix timestamp when the listing was cached.
+	Created int64 `json:"created"`
+}
+
+// listingEntry is a single object in a cached bucket listing.
+type listingEntry struct {
+	Key     string    `json:"key"`
+	ModTime time.Time `json:"modtime"`
+	Size    int64     `json:"size"`
+	MD5     []byte    `json:"md5,omitempty"`
+}
+
+// listingCache persists bucket listings (keys and their attributes) to a `gocloud.dev/blob.Bucket` so that
+// subsequent runs can iterate over them without listing the source bucket again.
+type listingCache struct {
+	// bucket is the `gocloud.dev/blob.Bucket` where cached listings are stored.
+	bucket *blob.Bucket
+	// ttl is the amount of time a cached listing is considered fresh.
+	ttl time.Duration
+	// logger is the `slog.Logger` instance used to log cache operations.
+	logger *slog.Logger
+}
+
+// newListingCache returns a new `listingCache` instance storing listings in the bucket defined by 'uri' and opened with 'mux'.
+func newListingCache(ctx context.Context, mux *blob.URLMux, uri string, ttl time.Duration) (*listingCache, error) {
+
+	b, err := mux.OpenBucket(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open listing cache bucket, %w", scrubError(err, uri))
+	}
+
+	c := &listingCache{
+		bucket: b,
+		ttl:    ttl,
+		logger: slog.Default(),
+	}
+
+	return c, nil
+}
+
+// Key returns the key in the cache for the listing of 'prefix' in the bucket identified by 'bucket_uri'.
+func (c *listingCache) Key(bucket_uri string, prefix string) string {
+
+	h := sha256.New()
+	h.Write([]byte(bucket_uri))
+	h.Write([]byte{0})
+	h.Write([]byte(prefix))
+
+	return hex.EncodeToString(h.Sum(nil)) + ".jsonl"
+}
+
+// Objects returns an `iter.Seq2[*blob.ListObject, error]` for each object in the cached listing 'key' and a boolean value
+// indicating whether a fresh listing was found. If the listing is missing or has expired the method returns false.
+func (c *listingCache) Objects(ctx context.Context, key string) (iter.Seq2[*blob.ListObject, error], bool) {
+
+	r, err := c.bucket.NewReader(ctx, key, nil)
+
+	if err != nil {
+
+		if gcerrors.Code(err) != gcerrors.NotFound {
+			c.logger.Warn("Failed to open cached listing", "key", key, "error", err)
+		}
+
+		return nil, false
+	}
+
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+
+	var hdr listingHeader
+	err = dec.Decode(&hdr)
+
+	if err != nil {
+		r.Close()
+		c.logger.Warn("Failed to read cached listing header", "key", key, "error", err)
+		return nil, false
+	}
+
+	created := time.Unix(hdr.Created, 0)
+
+	if time.Since(created) > c.ttl {
+		r.Close()
+		c.logger.Debug("Cached listing has expired", "key", key, "prefix", hdr.Prefix, "created", created)
+		return nil, false
+	}
+
+	seq := func(yield func(obj *blob.ListObject, err error) bool) {
+
+		defer r.Close()
+
+		for {
+
+			var e listingEntry
+			err := dec.Decode(&e)
+
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to read cached listing %s, %w", key, err))
+				return
+			}
+
+			obj := &blob.ListObject{
+				Key:     e.Key,
+				ModTime: e.ModTime,
+				Size:    e.Size,
+				MD5:     e.MD5,
+			}
+
+			if !yield(obj, nil) {
+				return
+			}
+		}
+	}
+
+	return seq, true
+}
+
+// listingWriter writes a bucket listing to a `listingCache`. The listing is only committed to the cache if
+// `Commit` is called; otherwise calling `Abort` discards it.
+type listingWriter struct {
+	wr     *blob.Writer
+	enc    *json.Encoder
+	cancel context.CancelFunc
+}
+
+// NewWriter returns a new `listingWriter` for the listing of 'prefix' to be stored in 'key'.
+func (c *listingCache) NewWriter(ctx context.Context, key string, prefix string) (*listingWriter, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	wr, err := c.bucket.NewWriter(ctx, key, nil)
+
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Failed to create listing cache writer, %w", err)
+	}
+
+	enc := json.NewEncoder(wr)
+
+	hdr := listingHeader{
+		Prefix:  prefix,
+		Created: time.Now().Unix(),
+	}
+
+	err = enc.Encode(hdr)
+
+	if err != nil {
+		cancel()
+		wr.Close()
+		return nil, fmt.Errorf("Failed to write listing cache header, %w", err)
+	}
+
+	w := &listingWriter{
+		wr:     wr,
+		enc:    enc,
+		cancel: cancel,
+	}
+
+	return w, nil
+}
+
+// Add appends 'obj' to the listing.
+func (w *listingWriter) Add(obj *blob.ListObject) error {
+
+	e := listingEntry{
+		Key:     obj.Key,
+		ModTime: obj.ModTime,
+		Size:    obj.Size,
+		MD5:     obj.MD5,
+	}
+
+	return w.enc.Encode(e)
+}
+
+// Commit stores the listing in the cache.
+func (w *listingWriter) Commit() error {
+	defer w.cancel()
+	return w.wr.Close()
+}
+
+// Abort discards the listing.
+func (w *listingWriter) Abort() {
+
+	// Cancelling the context before closing a blob.Writer ensures that nothing is written.
+	w.cancel()
+	w.wr.Close()
+}
+
+// Invalidate removes the cached listing 'key'. It is not an error if the listing does not exist.
+func (c *listingCache) Invalidate(ctx context.Context, key string) error {
+
+	err := c.bucket.Delete(ctx, key)
+
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the underlying cache bucket.
+func (c *listingCache) Close() error {
+	return c.bucket.Close()
+}
+
+// cachedListObjects returns an `iter.Seq2[*blob.ListObject, error]` for each object in the bucket contained by 'uri',
+// reading from the iterator's listing cache if a fresh listing is available or listing the bucket (and caching the
+// results) if not.
+func (it *BucketIterator) cachedListObjects(ctx context.Context, uri string) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(obj *blob.ListObject, err error) bool) {
+
+		prefix := listingPrefix(uri)
+		key := it.listing_cache.Key(it.bucket_uri, prefix)
+
+		cached, ok := it.listing_cache.Objects(ctx, key)
+
+		if ok {
+
+			it.logger.Debug("Read listing from cache", "prefix", prefix, "key", key)
+
+			for obj, err := range cached {
+
+				if !yield(obj, err) || err != nil {
+					return
+				}
+			}
+
+			return
+		}
+
+		wr, err := it.listing_cache.NewWriter(ctx, key, prefix)
+
+		if err != nil {
+			it.logger.Warn("Failed to create listing cache writer, listing will not be cached", "prefix", prefix, "error", err)
+			wr = nil
+		}
+
+		completed := false
+
+		defer func() {
+
+			if wr == nil {
+				return
+			}
+
+			if !completed {
+				wr.Abort()
+				return
+			}
+
+			err := wr.Commit()
+
+			if err != nil {
+				it.logger.Warn("Failed to commit listing to cache", "prefix", prefix, "error", err)
+			}
+		}()
+
+		for obj, err := range it.listBucket(ctx, uri) {
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if wr != nil {
+
+				err := wr.Add(obj)
+
+				if err != nil {
+					it.logger.Warn("Failed to add object to cached listing, listing will not be cached", "prefix", prefix, "error", err)
+					wr.Abort()
+					wr = nil
+				}
+			}
+
+			if !yield(obj, nil) {
+				return
+			}
+		}
+
+		completed = true
+	}
+}
+
+// InvalidateListings removes any cached listings for 'uris' from the iterator's listing cache. If the iterator was
+// not configured with a listing cache this method is a no-op.
+func (it *BucketIterator) InvalidateListings(ctx context.Context, uris ...string) error {
+
+	if it.listing_cache == nil {
+		return nil
+	}
+
+	for _, uri := range uris {
+
+		prefix := listingPrefix(uri)
+		key := it.listing_cache.Key(it.bucket_uri, prefix)
+
+		err := it.listing_cache.Invalidate(ctx, key)
+
+		if err != nil {
+			return fmt.Errorf("Failed to invalidate cached listing for '%s', %w", uri, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBucketIteratorListingCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	bucket_dir := t.TempDir()
+	cache_dir := t.TempDir()
+
+	fixtures := []string{
+		"fixtures/data/136/039/134/1/1360391341.geojson",
+		"fixtures/data/136/039/134/3/1360391343.geojson",
+	}
+
+	for _, path := range fixtures {
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", path, err)
+		}
+
+		err = os.WriteFile(filepath.Join(bucket_dir, filepath.Base(path)), body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+	}
+
+	listing_cache := fmt.Sprintf("file://%s", cache_dir)
+	iter_uri := fmt.Sprintf("bucket-file://%s?listing_cache=%s", bucket_dir, url.QueryEscape(listing_cache))
+
+	count := func() int {
+
+		it, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator, %v", err)
+		}
+
+		defer it.Close()
+
+		count := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket, %v", err)
+			}
+
+			rec.Body.Close()
+			count += 1
+		}
+
+		return count
+	}
+
+	if c := count(); c != 2 {
+		t.Fatalf("Expected 2 records on first run, but counted %d", c)
+	}
+
+	// Add a new object; it should not be seen until the cached listing is invalidated
+
+	body, err := os.ReadFile("fixtures/data/136/039/134/5/1360391345.geojson")
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(bucket_dir, "1360391345.geojson"), body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write fixture, %v", err)
+	}
+
+	if c := count(); c != 2 {
+		t.Fatalf("Expected 2 records from cached listing, but counted %d", c)
+	}
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	err = it.(*BucketIterator).InvalidateListings(ctx, ".")
+
+	if err != nil {
+		t.Fatalf("Failed to invalidate listings, %v", err)
+	}
+
+	it.Close()
+
+	if c := count(); c != 3 {
+		t.Fatalf("Expected 3 records after invalidating listing, but counted %d", c)
+	}
+}
@@ -0,0 +1,38 @@
+package bucket
+
+import (
+	"log/slog"
+)
+
+// SetLogger replaces the `slog.Logger` used to log the iterator's listing, fetching, retry and stats messages. The
+// bucket URI, if known, is attached to each message as the "bucket_uri" attribute after being scrubbed of sensitive
+// data (see `ScrubURI`). If 'logger' is nil `slog.Default()` is used. It should be called before iteration starts.
+func (it *BucketIterator) SetLogger(logger *slog.Logger) {
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if it.bucket_uri != "" {
+		logger = logger.With("bucket_uri", scrubURI(it.bucket_uri))
+	}
+
+	it.logger = logger
+	it.retry.logger = logger
+
+	if it.get_concurrency != nil {
+		it.get_concurrency.logger = logger
+	}
+
+	if it.list_concurrency != nil {
+		it.list_concurrency.logger = logger
+	}
+
+	if it.cache != nil {
+		it.cache.logger = logger
+	}
+
+	if it.listing_cache != nil {
+		it.listing_cache.logger = logger
+	}
+}
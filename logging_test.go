@@ -0,0 +1,143 @@
+package bucket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+func TestSetLogger(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	cache_dir := t.TempDir()
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	logger = logger.With("job_id", "test-job")
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?cache_dir=%s&_with_stats=false", abs_path, cache_dir)
+
+	for i := 0; i < 2; i++ {
+
+		it, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create iterator, %v", err)
+		}
+
+		bucket_it := it.(*BucketIterator)
+		bucket_it.SetLogger(logger)
+
+		for rec, err := range it.Iterate(ctx, "136/") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate, %v", err)
+			}
+
+			rec.Body.Close()
+		}
+
+		bucket_it.logStats(ctx)
+		it.Close()
+	}
+
+	messages := map[string]int{}
+
+	scanner := bufio.NewScanner(&buf)
+
+	for scanner.Scan() {
+
+		var entry map[string]any
+
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+
+		if err != nil {
+			t.Fatalf("Failed to decode log entry, %v", err)
+		}
+
+		if entry["job_id"] != "test-job" {
+			t.Fatalf("Log entry is missing job ID: %s", scanner.Text())
+		}
+
+		if entry["bucket_uri"] != fmt.Sprintf("file://%s", abs_path) {
+			t.Fatalf("Log entry is missing bucket URI: %s", scanner.Text())
+		}
+
+		messages[entry["msg"].(string)] += 1
+	}
+
+	if messages["Read object from cache"] == 0 {
+		t.Fatalf("Expected cache reads to be logged, %v", messages)
+	}
+
+	if messages["Bucket iterator stats"] != 2 {
+		t.Fatalf("Expected stats to be logged twice, %v", messages)
+	}
+}
+
+func TestBucketIteratorOptionsLogger(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	b, err := blob.OpenBucket(ctx, fmt.Sprintf("file://%s", abs_path))
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer b.Close()
+
+	var buf bytes.Buffer
+
+	opts := DefaultBucketIteratorOptions()
+	opts.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	opts.BucketURI = "file:///fixtures"
+	opts.Mode = MODE_GEOJSONL
+	opts.WithStats = false
+
+	it, err := NewBucketIteratorWithBucket(ctx, b, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	for rec, err := range it.Iterate(ctx, "136/") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"msg":"Skip non-GeoJSONL object"`)) {
+		t.Fatalf("Expected skipped objects to be logged, %s", buf.String())
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"bucket_uri":"file:///fixtures"`)) {
+		t.Fatalf("Expected bucket URI to be attached, %s", buf.String())
+	}
+}
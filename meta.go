@@ -0,0 +1,247 @@
+package bucket
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/blob"
+)
+
+// metaFilters defines criteria for including or excluding rows in Who's On First "meta" CSV files.
+type metaFilters struct {
+	// placetypes is a lookup table of placetypes that rows must match. If empty all placetypes are matched.
+	placetypes map[string]bool
+	// is_current is a lookup table of "is_current" values that rows must match. If empty all values are matched.
+	is_current map[string]bool
+	// lastmodified_min is the minimum "lastmodified" time that rows must match. If 0 it is ignored.
+	lastmodified_min int64
+	// lastmodified_max is the maximum "lastmodified" time that rows must match. If 0 it is ignored.
+	lastmodified_max int64
+}
+
+// newMetaFilters returns a new `metaFilters` instance for rows that match any of 'placetypes' (or all placetypes if empty),
+// any of the "is_current" values in 'is_current' (or all values if empty) and whose "lastmodified" time is between
+// 'lastmodified_min' and 'lastmodified_max' (either of which is ignored if 0).
+func newMetaFilters(placetypes []string, is_current []int, lastmodified_min int64, lastmodified_max int64) *metaFilters {
+
+	f := &metaFilters{
+		placetypes:       make(map[string]bool),
+		is_current:       make(map[string]bool),
+		lastmodified_min: lastmodified_min,
+		lastmodified_max: lastmodified_max,
+	}
+
+	for _, pt := range placetypes {
+		f.placetypes[pt] = true
+	}
+
+	for _, v := range is_current {
+		f.is_current[strconv.Itoa(v)] = true
+	}
+
+	return f
+}
+
+// Matches returns a boolean value indicating whether 'row' matches all the criteria defined by 'f'.
+func (f *metaFilters) Matches(row map[string]string) (bool, error) {
+
+	if len(f.placetypes) > 0 {
+
+		pt, ok := row["placetype"]
+
+		if !ok {
+			return false, fmt.Errorf("Missing 'placetype' column")
+		}
+
+		if !f.placetypes[pt] {
+			return false, nil
+		}
+	}
+
+	if len(f.is_current) > 0 {
+
+		v, ok := row["is_current"]
+
+		if !ok {
+			return false, fmt.Errorf("Missing 'is_current' column")
+		}
+
+		if !f.is_current[v] {
+			return false, nil
+		}
+	}
+
+	if f.lastmodified_min != 0 || f.lastmodified_max != 0 {
+
+		v, ok := row["lastmodified"]
+
+		if !ok {
+			return false, fmt.Errorf("Missing 'lastmodified' column")
+		}
+
+		lastmod, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil {
+			return false, fmt.Errorf("Failed to parse 'lastmodified' column, %w", err)
+		}
+
+		if f.lastmodified_min != 0 && lastmod < f.lastmodified_min {
+			return false, nil
+		}
+
+		if f.lastmodified_max != 0 && lastmod > f.lastmodified_max {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// isMetaObject returns a boolean value indicating whether 'key', ignoring any compression extension, is a CSV file.
+func isMetaObject(key string) bool {
+
+	key = strings.ToLower(key)
+
+	if compressionFromExtension(key) != COMPRESSION_NONE {
+		key = strings.TrimSuffix(key, filepath.Ext(key))
+	}
+
+	return strings.HasSuffix(key, ".csv")
+}
+
+// metaObjects returns an `iter.Seq2[*blob.ListObject, error]` for each object in the "data" folder contained by 'uri' whose
+// row in the (optionally compressed) CSV files in the "meta" folder contained by 'uri' matches the iterator's meta filters.
+// Only the "meta" folder is listed; objects in the "data" folder are not checked for existence until they are opened.
+func (it *BucketIterator) metaObjects(ctx context.Context, uri string) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(obj *blob.ListObject, err error) bool) {
+
+		root := listingPrefix(uri)
+
+		meta_uri := path.Join(root, "meta")
+		data_root := path.Join(root, "data")
+
+		// Individual records may be listed in more than one meta file
+		seen := make(map[string]bool)
+
+		for meta_obj, err := range it.listObjects(ctx, meta_uri) {
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if !isMetaObject(meta_obj.Key) {
+				continue
+			}
+
+			it.logger.Debug("Read meta file", "key", meta_obj.Key)
+
+			for row, err := range it.metaRows(ctx, meta_obj) {
+
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+
+				ok, err := it.meta_filters.Matches(row)
+
+				if err != nil {
+					yield(nil, fmt.Errorf("Failed to apply meta filters for row in %s, %w", meta_obj.Key, err))
+					return
+				}
+
+				if !ok {
+					continue
+				}
+
+				rel_path, ok := row["path"]
+
+				if !ok || rel_path == "" {
+					yield(nil, fmt.Errorf("Row in %s is missing 'path' column", meta_obj.Key))
+					return
+				}
+
+				key := path.Join(data_root, rel_path)
+
+				if seen[key] {
+					continue
+				}
+
+				seen[key] = true
+
+				obj := &blob.ListObject{
+					Key: key,
+				}
+
+				if !yield(obj, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// metaRows returns an `iter.Seq2[map[string]string, error]` for each row in the CSV file 'obj' keyed by the column names in its header.
+func (it *BucketIterator) metaRows(ctx context.Context, obj *blob.ListObject) iter.Seq2[map[string]string, error] {
+
+	return func(yield func(row map[string]string, err error) bool) {
+
+		key := obj.Key
+
+		r, err := it.openStream(ctx, obj)
+
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		defer r.Close()
+
+		csv_r := csv.NewReader(r)
+		csv_r.ReuseRecord = true
+
+		header, err := csv_r.Read()
+
+		if err != nil {
+			yield(nil, fmt.Errorf("Failed to read header for %s, %w", key, err))
+			return
+		}
+
+		header = append([]string{}, header...)
+
+		for {
+
+			record, err := csv_r.Read()
+
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to read row in %s, %w", key, err))
+				return
+			}
+
+			row := make(map[string]string, len(header))
+
+			for i, k := range header {
+
+				if i < len(record) {
+					row[k] = record[i]
+				}
+			}
+
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+)
+
+func TestBucketIteratorMeta(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	tests := map[string]int{
+		"source=meta":                                     37,
+		"source=meta&meta_placetype=custom":               37,
+		"source=meta&meta_placetype=locality":             0,
+		"source=meta&meta_is_current=1":                   12,
+		"source=meta&meta_is_current=1&meta_is_current=0": 18,
+		"source=meta&meta_lastmodified_min=1628543477":    3,
+		"source=meta&meta_lastmodified_max=1619637073":    2,
+	}
+
+	for params, expected := range tests {
+
+		iter_uri := fmt.Sprintf("bucket-file://%s?%s", abs_path, params)
+
+		it, err := iterate.NewIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator for '%s', %v", params, err)
+		}
+
+		count := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket for '%s', %v", params, err)
+			}
+
+			rec.Body.Close()
+
+			if !strings.HasPrefix(rec.Path, "data/") {
+				t.Fatalf("Unexpected path '%s'", rec.Path)
+			}
+
+			count += 1
+		}
+
+		it.Close()
+
+		if count != expected {
+			t.Fatalf("Expected %d records for '%s', but counted %d", expected, params, count)
+		}
+	}
+}
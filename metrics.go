@@ -0,0 +1,168 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"gocloud.dev/gcerrors"
+)
+
+// INSTRUMENTATION_NAME is the name used to create OpenTelemetry meters (and tracers) for bucket iterators.
+const INSTRUMENTATION_NAME string = "github.com/whosonfirst/go-whosonfirst-iterate-bucket/v3"
+
+// metricsPrefixKey is the context key used to store the listing prefix of the URI being iterated.
+type metricsPrefixKey struct{}
+
+// withMetricsPrefix returns a copy of 'ctx' carrying 'prefix' which is used to label the metrics recorded with that context.
+func withMetricsPrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, metricsPrefixKey{}, prefix)
+}
+
+// iteratorMetrics records OpenTelemetry metrics for a bucket iterator. All measurements are labelled with the
+// bucket's scheme and the listing prefix of the URI being iterated.
+type iteratorMetrics struct {
+	// scheme is the `gocloud.dev/blob` scheme of the bucket being iterated.
+	scheme string
+	// keys_listed counts the keys derived from the iterator's source.
+	keys_listed metric.Int64Counter
+	// objects_opened counts the objects opened for reading.
+	objects_opened metric.Int64Counter
+	// bytes_read counts the bytes read from the bucket.
+	bytes_read metric.Int64Counter
+	// records_filtered counts the records excluded by the iterator's filters.
+	records_filtered metric.Int64Counter
+	// errors counts errors, labelled by their `gocloud.dev/gcerrors` code.
+	errors metric.Int64Counter
+	// list_latency records the latency of listing requests.
+	list_latency metric.Float64Histogram
+	// get_latency records the latency of object read and attribute requests.
+	get_latency metric.Float64Histogram
+}
+
+// newIteratorMetrics returns a new `iteratorMetrics` instance for a bucket with 'scheme' whose instruments are created by 'mp'.
+func newIteratorMetrics(mp metric.MeterProvider, scheme string) (*iteratorMetrics, error) {
+
+	meter := mp.Meter(INSTRUMENTATION_NAME)
+
+	m := &iteratorMetrics{
+		scheme: scheme,
+	}
+
+	var err error
+
+	m.keys_listed, err = meter.Int64Counter("bucket.iterator.keys_listed", metric.WithDescription("The number of keys derived from the iterator's source."))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create keys listed counter, %w", err)
+	}
+
+	m.objects_opened, err = meter.Int64Counter("bucket.iterator.objects_opened", metric.WithDescription("The number of objects opened for reading."))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create objects opened counter, %w", err)
+	}
+
+	m.bytes_read, err = meter.Int64Counter("bucket.iterator.bytes_read", metric.WithDescription("The number of bytes read from the bucket."), metric.WithUnit("By"))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create bytes read counter, %w", err)
+	}
+
+	m.records_filtered, err = meter.Int64Counter("bucket.iterator.records_filtered", metric.WithDescription("The number of records excluded by the iterator's filters."))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create records filtered counter, %w", err)
+	}
+
+	m.errors, err = meter.Int64Counter("bucket.iterator.errors", metric.WithDescription("The number of errors encountered, by gocloud.dev/gcerrors code."))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create errors counter, %w", err)
+	}
+
+	m.list_latency, err = meter.Float64Histogram("bucket.iterator.list.duration", metric.WithDescription("The latency of listing requests."), metric.WithUnit("s"))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create list latency histogram, %w", err)
+	}
+
+	m.get_latency, err = meter.Float64Histogram("bucket.iterator.get.duration", metric.WithDescription("The latency of object read and attribute requests."), metric.WithUnit("s"))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create get latency histogram, %w", err)
+	}
+
+	return m, nil
+}
+
+// attributes returns the attributes for measurements recorded with 'ctx' along with any 'extras'.
+func (m *iteratorMetrics) attributes(ctx context.Context, extras ...attribute.KeyValue) metric.MeasurementOption {
+
+	prefix, _ := ctx.Value(metricsPrefixKey{}).(string)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("scheme", m.scheme),
+		attribute.String("prefix", prefix),
+	}
+
+	attrs = append(attrs, extras...)
+	return metric.WithAttributes(attrs...)
+}
+
+// KeyListed records that a key was derived from the iterator's source.
+func (m *iteratorMetrics) KeyListed(ctx context.Context) {
+	m.keys_listed.Add(ctx, 1, m.attributes(ctx))
+}
+
+// ObjectOpened records that an object was opened for reading.
+func (m *iteratorMetrics) ObjectOpened(ctx context.Context) {
+	m.objects_opened.Add(ctx, 1, m.attributes(ctx))
+}
+
+// BytesRead records that 'n' bytes were read from the bucket.
+func (m *iteratorMetrics) BytesRead(ctx context.Context, n int64) {
+	m.bytes_read.Add(ctx, n, m.attributes(ctx))
+}
+
+// RecordFiltered records that a record was excluded by 'filter'.
+func (m *iteratorMetrics) RecordFiltered(ctx context.Context, filter string) {
+	m.records_filtered.Add(ctx, 1, m.attributes(ctx, attribute.String("filter", filter)))
+}
+
+// Error records 'err' labelled by its `gocloud.dev/gcerrors` code.
+func (m *iteratorMetrics) Error(ctx context.Context, err error) {
+	m.errors.Add(ctx, 1, m.attributes(ctx, attribute.String("code", gcerrors.Code(err).String())))
+}
+
+// ListLatency records the latency of a listing request which started at 'start'.
+func (m *iteratorMetrics) ListLatency(ctx context.Context, start time.Time) {
+	m.list_latency.Record(ctx, time.Since(start).Seconds(), m.attributes(ctx))
+}
+
+// GetLatency records the latency of an object read or attribute request which started at 'start'.
+func (m *iteratorMetrics) GetLatency(ctx context.Context, start time.Time) {
+	m.get_latency.Record(ctx, time.Since(start).Seconds(), m.attributes(ctx))
+}
+
+// SetMeterProvider replaces the `metric.MeterProvider` used to record the iterator's OpenTelemetry metrics. By default
+// iterators use a no-op provider and record nothing. It should be called before iteration starts.
+func (it *BucketIterator) SetMeterProvider(mp metric.MeterProvider) error {
+
+	m, err := newIteratorMetrics(mp, it.metrics.scheme)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create iterator metrics, %w", err)
+	}
+
+	it.metrics = m
+	return nil
+}
+
+// newNoopIteratorMetrics returns a new `iteratorMetrics` instance for 'scheme' which records nothing.
+func newNoopIteratorMetrics(scheme string) (*iteratorMetrics, error) {
+	return newIteratorMetrics(noop.NewMeterProvider(), scheme)
+}
@@ -0,0 +1,193 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"gocloud.dev/blob"
+)
+
+func TestBucketIteratorMetrics(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?include=properties.mz:is_current=1", abs_path)
+
+	iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	it := iter.(*BucketIterator)
+
+	err = it.SetMeterProvider(mp)
+
+	if err != nil {
+		t.Fatalf("Failed to set meter provider, %v", err)
+	}
+
+	count := int64(0)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count == 0 || count == 37 {
+		t.Fatalf("Expected some, but not all, records to be filtered, counted %d", count)
+	}
+
+	var rm metricdata.ResourceMetrics
+
+	err = reader.Collect(ctx, &rm)
+
+	if err != nil {
+		t.Fatalf("Failed to collect metrics, %v", err)
+	}
+
+	sums := make(map[string]int64)
+	histograms := make(map[string]uint64)
+
+	for _, sm := range rm.ScopeMetrics {
+
+		for _, m := range sm.Metrics {
+
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+
+				for _, dp := range data.DataPoints {
+
+					scheme, _ := dp.Attributes.Value("scheme")
+
+					if scheme.AsString() != "file" {
+						t.Fatalf("Unexpected scheme attribute for %s, %s", m.Name, scheme.AsString())
+					}
+
+					sums[m.Name] += dp.Value
+				}
+
+			case metricdata.Histogram[float64]:
+
+				for _, dp := range data.DataPoints {
+					histograms[m.Name] += dp.Count
+				}
+			}
+		}
+	}
+
+	if sums["bucket.iterator.keys_listed"] != 37 {
+		t.Fatalf("Expected 37 keys listed, but got %d", sums["bucket.iterator.keys_listed"])
+	}
+
+	if sums["bucket.iterator.objects_opened"] != 37 {
+		t.Fatalf("Expected 37 objects opened, but got %d", sums["bucket.iterator.objects_opened"])
+	}
+
+	if sums["bucket.iterator.records_filtered"] != 37-count {
+		t.Fatalf("Expected %d records filtered, but got %d", 37-count, sums["bucket.iterator.records_filtered"])
+	}
+
+	if sums["bucket.iterator.bytes_read"] != it.BytesRead() {
+		t.Fatalf("Expected %d bytes read, but got %d", it.BytesRead(), sums["bucket.iterator.bytes_read"])
+	}
+
+	if histograms["bucket.iterator.get.duration"] != 37 {
+		t.Fatalf("Expected 37 get latency measurements, but got %d", histograms["bucket.iterator.get.duration"])
+	}
+
+	if histograms["bucket.iterator.list.duration"] == 0 {
+		t.Fatalf("Expected list latency measurements")
+	}
+}
+
+func TestBucketIteratorMeterProviderOption(t *testing.T) {
+
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"a.geojson": `{"type": "Feature"}`,
+		"b.geojson": `{"type": "Feature"}`,
+		"c.geojson": `{"type": "Feature"}`,
+	}
+
+	reader := sdkmetric.NewManualReader()
+
+	opts := DefaultBucketIteratorOptions()
+	opts.BucketURI = "mem://"
+	opts.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	it, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(newFakeBucket(objects)), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	var rm metricdata.ResourceMetrics
+
+	err = reader.Collect(ctx, &rm)
+
+	if err != nil {
+		t.Fatalf("Failed to collect metrics, %v", err)
+	}
+
+	listed := int64(0)
+
+	for _, sm := range rm.ScopeMetrics {
+
+		for _, m := range sm.Metrics {
+
+			data, ok := m.Data.(metricdata.Sum[int64])
+
+			if !ok || m.Name != "bucket.iterator.keys_listed" {
+				continue
+			}
+
+			for _, dp := range data.DataPoints {
+
+				scheme, _ := dp.Attributes.Value("scheme")
+
+				if scheme.AsString() != "mem" {
+					t.Fatalf("Unexpected scheme attribute for %s, %s", m.Name, scheme.AsString())
+				}
+
+				listed += dp.Value
+			}
+		}
+	}
+
+	if listed != 3 {
+		t.Fatalf("Expected 3 keys listed, but got %d", listed)
+	}
+}
@@ -0,0 +1,83 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+)
+
+// RegisterSchemesWithMux registers an iterator scheme for each of the schemes registered with 'mux', using 'prefix'
+// instead of "bucket-", whose buckets (including any listing cache and dead-letter buckets) are opened using 'mux'
+// rather than `blob.DefaultURLMux()`. For example if 'mux' has an "internal-s3" scheme and 'prefix' is "internal-"
+// then "internal-internal-s3://" URIs will be iterated using buckets opened by 'mux'. Multiple muxes may be registered
+// side by side as long as their prefixes do not produce the same schemes. It is safe to call more than once with the
+// same 'mux' and 'prefix' in order to register schemes added to 'mux' since the last call.
+func RegisterSchemesWithMux(ctx context.Context, mux *blob.URLMux, prefix string) error {
+
+	if mux == nil {
+		return fmt.Errorf("Missing mux")
+	}
+
+	if prefix == "" {
+		return fmt.Errorf("Missing prefix")
+	}
+
+	register_mu.Lock()
+	defer register_mu.Unlock()
+
+	init_func := func(ctx context.Context, uri string) (iterate.Iterator, error) {
+		return NewBucketIteratorWithMux(ctx, mux, prefix, uri)
+	}
+
+	return registerMuxSchemes(ctx, mux, prefix, init_func)
+}
+
+// NewBucketIteratorWithMux returns a new `BucketIterator` for 'uri', whose scheme is a scheme registered with 'mux' with
+// 'prefix' prepended, opening buckets using 'mux'. The parameters for 'uri' are the same as those for `NewBucketIterator`.
+func NewBucketIteratorWithMux(ctx context.Context, mux *blob.URLMux, prefix string, uri string) (iterate.Iterator, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, scrubError(err, uri)
+	}
+
+	return newBucketIterator(ctx, mux, prefix, u)
+}
+
+// registerMuxSchemes registers 'init_func' for each of the schemes registered with 'mux' with 'prefix' prepended. Schemes
+// which have already been registered for 'mux' are skipped. It is an error if a scheme has already been registered for a
+// different mux. The caller must hold 'register_mu'.
+func registerMuxSchemes(ctx context.Context, mux *blob.URLMux, prefix string, init_func iterate.IteratorInitializationFunc) error {
+
+	for _, scheme := range mux.BucketSchemes() {
+
+		scheme = prefix + scheme
+		slog.Debug("Register bucket iterator scheme", "scheme", scheme)
+
+		registered_mux, exists := register_map[scheme]
+
+		if exists {
+
+			if registered_mux != mux {
+				return fmt.Errorf("Failed to register blob writer for '%s', scheme is already registered with a different mux", scheme)
+			}
+
+			continue
+		}
+
+		err := iterate.RegisterIterator(ctx, scheme, init_func)
+
+		if err != nil {
+			return fmt.Errorf("Failed to register blob writer for '%s', %w", scheme, err)
+		}
+
+		register_map[scheme] = mux
+	}
+
+	return nil
+}
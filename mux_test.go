@@ -0,0 +1,144 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+)
+
+func TestRegisterSchemesWithMux(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	alpha_mux := new(blob.URLMux)
+	alpha_mux.RegisterBucket("internal-file", &fileblob.URLOpener{})
+
+	beta_mux := new(blob.URLMux)
+	beta_mux.RegisterBucket("internal-file", &fileblob.URLOpener{})
+
+	err = RegisterSchemesWithMux(ctx, alpha_mux, "alpha-")
+
+	if err != nil {
+		t.Fatalf("Failed to register alpha mux, %v", err)
+	}
+
+	err = RegisterSchemesWithMux(ctx, beta_mux, "beta-")
+
+	if err != nil {
+		t.Fatalf("Failed to register beta mux, %v", err)
+	}
+
+	// Registering the same mux again is a no-op
+
+	err = RegisterSchemesWithMux(ctx, alpha_mux, "alpha-")
+
+	if err != nil {
+		t.Fatalf("Failed to re-register alpha mux, %v", err)
+	}
+
+	err = RegisterSchemesWithMux(ctx, beta_mux, "alpha-")
+
+	if err == nil {
+		t.Fatalf("Expected registering a different mux with the same prefix to fail")
+	}
+
+	for _, prefix := range []string{"alpha-", "beta-"} {
+
+		iter_uri := fmt.Sprintf("%sinternal-file://%s", prefix, abs_path)
+
+		it, err := iterate.NewIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create iterator for %s, %v", iter_uri, err)
+		}
+
+		count := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate %s, %v", iter_uri, err)
+			}
+
+			rec.Body.Close()
+			count += 1
+		}
+
+		it.Close()
+
+		if count != 37 {
+			t.Fatalf("Unexpected count for %s: %d", iter_uri, count)
+		}
+	}
+
+	// The default mux does not know about "internal-file"
+
+	_, err = NewBucketIterator(ctx, fmt.Sprintf("bucket-internal-file://%s", abs_path))
+
+	if err == nil {
+		t.Fatalf("Expected default mux to fail to open internal-file bucket")
+	}
+}
+
+func TestNewBucketIteratorWithMuxListingCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	cache_dir := t.TempDir()
+
+	mux := new(blob.URLMux)
+	mux.RegisterBucket("custom", &fileblob.URLOpener{})
+
+	iter_uri := fmt.Sprintf("custom-custom://%s?listing_cache=custom://%s", abs_path, cache_dir)
+
+	it, err := NewBucketIteratorWithMux(ctx, mux, "custom-", iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != 37 {
+		t.Fatalf("Unexpected count: %d", count)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(cache_dir, "*"))
+
+	if err != nil {
+		t.Fatalf("Failed to list cache dir, %v", err)
+	}
+
+	if len(matches) == 0 {
+		t.Fatalf("Expected listing cache to be written using custom mux")
+	}
+}
@@ -0,0 +1,843 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3/filters"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/blob"
+)
+
+// valid_modes are the valid values for `BucketIteratorOptions.Mode`.
+var valid_modes = []string{MODE_OBJECT, MODE_ARCHIVE, MODE_GEOJSONL, MODE_FEATURECOLLECTION}
+
+// valid_sources are the valid values for `BucketIteratorOptions.Source`.
+var valid_sources = []string{SOURCE_LIST, SOURCE_META, SOURCE_DEAD_LETTER}
+
+// valid_error_policies are the valid values for `BucketIteratorOptions.OnError`.
+var valid_error_policies = []string{ON_ERROR_FAIL, ON_ERROR_SKIP, ON_ERROR_COLLECT}
+
+// resume_params are the `whosonfirst/go-whosonfirst-iterate/v3` retry parameters which are handled by the bucket
+// iterator, rather than the concurrent iterator which wraps it, when resuming is enabled.
+var resume_params = []string{"_retry", "_max_retries", "_retry_after"}
+
+// BucketIteratorOptions defines the configuration for a `BucketIterator`. Each field corresponds to one of the query
+// parameters described in `NewBucketIterator`. Use `DefaultBucketIteratorOptions` to start from the same defaults used
+// for URIs. Zero-valued strings, durations, sizes and concurrency bounds are replaced by their defaults.
+type BucketIteratorOptions struct {
+	// Filters is an optional `filters.Filters` instance used to include or exclude specific records from being crawled.
+	Filters filters.Filters
+	// Mode is the iteration mode which determines how objects in a bucket are converted in to records. (Default is `MODE_OBJECT`.)
+	Mode string
+	// Source is the key source which determines how the objects to process are derived. (Default is `SOURCE_LIST`.)
+	Source string
+	// MetaPlacetypes are the placetypes that rows in "meta" CSV files must match to be processed when Source is `SOURCE_META`.
+	MetaPlacetypes []string
+	// MetaIsCurrent are the "is_current" values that rows in "meta" CSV files must match to be processed when Source is `SOURCE_META`.
+	MetaIsCurrent []int
+	// MetaLastModifiedMin is the minimum (Unix) "lastmodified" time that rows in "meta" CSV files must match. If 0 it is ignored.
+	MetaLastModifiedMin int64
+	// MetaLastModifiedMax is the maximum (Unix) "lastmodified" time that rows in "meta" CSV files must match. If 0 it is ignored.
+	MetaLastModifiedMax int64
+	// DryRun signals that records should be derived from the bucket listing alone, without opening any objects. Filters are
+	// applied to the JSON-encoded `DryRunMetadata` for each object rather than its body.
+	DryRun bool
+	// Decompress signals that compressed objects should be decompressed before being yielded.
+	Decompress bool
+	// CheckContentEncoding signals that an object's "Content-Encoding" attribute should be consulted when detecting compression.
+	CheckContentEncoding bool
+	// MaxRetries is the maximum number of times individual bucket operations are retried if they fail with a transient error.
+	MaxRetries int
+	// RetryInitialDelay is the maximum amount of time to wait before the first retry.
+	RetryInitialDelay time.Duration
+	// RetryMaxDelay is the upper bound for the delay between retries.
+	RetryMaxDelay time.Duration
+	// MaxListRPS is the maximum number of listing requests per second. If 0 it is unlimited.
+	MaxListRPS float64
+	// MaxGetRPS is the maximum number of object read and attribute requests per second. If 0 it is unlimited.
+	MaxGetRPS float64
+	// MaxBytesPerSecond is the maximum combined number of bytes per second read from the bucket. If 0 it is unlimited.
+	MaxBytesPerSecond float64
+	// AdaptiveConcurrency signals that the number of concurrent requests should be adjusted based on observed latency and throttling errors.
+	AdaptiveConcurrency bool
+	// MinGetConcurrency is the lower bound for the number of concurrent object read and attribute requests when adaptive concurrency is enabled.
+	MinGetConcurrency int
+	// MaxGetConcurrency is the upper bound for the number of concurrent object read and attribute requests when adaptive concurrency is enabled.
+	MaxGetConcurrency int
+	// MinListConcurrency is the lower bound for the number of concurrent listing requests when adaptive concurrency is enabled.
+	MinListConcurrency int
+	// MaxListConcurrency is the upper bound for the number of concurrent listing requests when adaptive concurrency is enabled.
+	MaxListConcurrency int
+	// AdaptiveLatencyTarget is the request latency above which adaptive concurrency is decreased.
+	AdaptiveLatencyTarget time.Duration
+	// WithStats signals that the iterator's stats should be logged periodically while records are being iterated. (Default is false.)
+	WithStats bool
+	// StatsInterval is the interval at which the iterator's stats are logged.
+	StatsInterval time.Duration
+	// StatsLevel is the (slog) level at which the iterator's stats are logged.
+	StatsLevel slog.Level
+	// Resume signals that a URI should be iterated again after a failed attempt, within the same call to `Iterate`, resuming
+	// after the last object that was processed in full rather than starting from the beginning.
+	Resume bool
+	// ResumeMaxAttempts is the maximum number of attempts to iterate a URI when Resume is true. (Default is `DEFAULT_RESUME_MAX_ATTEMPTS`.)
+	ResumeMaxAttempts int
+	// ResumeDelay is the amount of time to wait before the first resumed attempt. It is multiplied by the number of attempts
+	// for subsequent ones. (Default is `DEFAULT_RESUME_DELAY`.)
+	ResumeDelay time.Duration
+	// OnError is the policy for handling errors processing individual objects. (Default is `ON_ERROR_FAIL`.)
+	OnError string
+	// DeadLetterURI is the `gocloud.dev/blob` URI where the dead-letter report is written (if OnError is `ON_ERROR_COLLECT`) or read from (if Source is `SOURCE_DEAD_LETTER`).
+	DeadLetterURI string
+	// DeadLetterKey is the key of the dead-letter report. (Default is `DEFAULT_DEAD_LETTER_KEY`.)
+	DeadLetterKey string
+	// CacheDir is an optional path to a local directory used to cache the bodies of objects between runs. Objects derived
+	// from "meta" files or dead-letter reports are never cached.
+	CacheDir string
+	// CacheMaxBytes is the maximum combined size, in bytes, of all the bodies in the cache. (Default is `DEFAULT_CACHE_MAX_BYTES`.)
+	CacheMaxBytes int64
+	// ListingCacheURI is an optional `gocloud.dev/blob` URI where bucket listings are cached between runs.
+	ListingCacheURI string
+	// ListingCacheTTL is the amount of time a cached listing is considered fresh. (Default is `DEFAULT_LISTING_CACHE_TTL`.)
+	ListingCacheTTL time.Duration
+	// URLMux is the `gocloud.dev/blob.URLMux` used to open the listing cache and dead-letter buckets. If nil `blob.DefaultURLMux()` is used.
+	URLMux *blob.URLMux
+	// Hooks is an optional `Hooks` instance whose callbacks are invoked at different points in the iterator's lifecycle.
+	Hooks *Hooks
+	// Logger is the `slog.Logger` instance used to log listing, fetching, retry and stats messages. The bucket URI, if
+	// known, is attached, scrubbed of sensitive data, to each message as the "bucket_uri" attribute. If nil `slog.Default()` is used.
+	Logger *slog.Logger
+	// MeterProvider is the `metric.MeterProvider` used to record the iterator's OpenTelemetry metrics. If nil a no-op provider
+	// is used and nothing is recorded.
+	MeterProvider metric.MeterProvider
+	// TracerProvider is the `trace.TracerProvider` used to create the iterator's OpenTelemetry spans. If nil a no-op provider
+	// is used and nothing is recorded.
+	TracerProvider trace.TracerProvider
+	// IteratorParameters are the "_"-prefixed `whosonfirst/go-whosonfirst-iterate/v3` parameters (for example `_include`,
+	// `_exclude`, `_exclude_alt`, `_dedupe`, `_retry` or `_max_procs`) used to configure the concurrent iterator which wraps
+	// the bucket iterator. Other parameters are ignored.
+	IteratorParameters url.Values
+	// BucketURI is the `gocloud.dev/blob` URI identifying the bucket being iterated. It is used to key cached objects and
+	// listings, so it is required if CacheDir or ListingCacheURI are set, and its scheme is used to label metrics.
+	BucketURI string
+}
+
+// DefaultBucketIteratorOptions returns a new `BucketIteratorOptions` instance with the same defaults used for URIs.
+func DefaultBucketIteratorOptions() *BucketIteratorOptions {
+
+	opts := &BucketIteratorOptions{
+		Mode:                  MODE_OBJECT,
+		Source:                SOURCE_LIST,
+		MaxRetries:            DEFAULT_MAX_RETRIES,
+		RetryInitialDelay:     DEFAULT_RETRY_INITIAL_DELAY,
+		RetryMaxDelay:         DEFAULT_RETRY_MAX_DELAY,
+		MinGetConcurrency:     DEFAULT_MIN_GET_CONCURRENCY,
+		MaxGetConcurrency:     DEFAULT_MAX_GET_CONCURRENCY,
+		MinListConcurrency:    DEFAULT_MIN_LIST_CONCURRENCY,
+		MaxListConcurrency:    DEFAULT_MAX_LIST_CONCURRENCY,
+		AdaptiveLatencyTarget: DEFAULT_ADAPTIVE_LATENCY_TARGET,
+		StatsInterval:         DEFAULT_STATS_INTERVAL,
+		StatsLevel:            slog.LevelInfo,
+		ResumeMaxAttempts:     DEFAULT_RESUME_MAX_ATTEMPTS,
+		ResumeDelay:           DEFAULT_RESUME_DELAY,
+		OnError:               ON_ERROR_FAIL,
+		DeadLetterKey:         DEFAULT_DEAD_LETTER_KEY,
+		CacheMaxBytes:         DEFAULT_CACHE_MAX_BYTES,
+		ListingCacheTTL:       DEFAULT_LISTING_CACHE_TTL,
+	}
+
+	return opts
+}
+
+// Validate returns an error if 'opts' are invalid. Zero values which are replaced by defaults are considered valid.
+func (opts *BucketIteratorOptions) Validate() error {
+
+	mode := defaultString(opts.Mode, MODE_OBJECT)
+	source := defaultString(opts.Source, SOURCE_LIST)
+	on_error := defaultString(opts.OnError, ON_ERROR_FAIL)
+
+	if !slices.Contains(valid_modes, mode) {
+		return fmt.Errorf("Invalid or unsupported mode '%s', must be one of: %s", mode, strings.Join(valid_modes, ", "))
+	}
+
+	if !slices.Contains(valid_sources, source) {
+		return fmt.Errorf("Invalid or unsupported source '%s', must be one of: %s", source, strings.Join(valid_sources, ", "))
+	}
+
+	if !slices.Contains(valid_error_policies, on_error) {
+		return fmt.Errorf("Invalid or unsupported error policy '%s', must be one of: %s", on_error, strings.Join(valid_error_policies, ", "))
+	}
+
+	if source == SOURCE_META && mode != MODE_OBJECT {
+		return fmt.Errorf("The '%s' source can only be used with the '%s' mode", SOURCE_META, MODE_OBJECT)
+	}
+
+	if (on_error == ON_ERROR_COLLECT || source == SOURCE_DEAD_LETTER) && opts.DeadLetterURI == "" {
+		return fmt.Errorf("Missing dead-letter URI, required when the error policy is '%s' or the source is '%s'", ON_ERROR_COLLECT, SOURCE_DEAD_LETTER)
+	}
+
+	if opts.MetaLastModifiedMin > 0 && opts.MetaLastModifiedMax > 0 && opts.MetaLastModifiedMin > opts.MetaLastModifiedMax {
+		return fmt.Errorf("Invalid minimum \"lastmodified\" time, must not be greater than maximum \"lastmodified\" time")
+	}
+
+	if opts.MaxRetries < 0 {
+		return fmt.Errorf("Invalid maximum retries, must not be negative")
+	}
+
+	if opts.ResumeMaxAttempts < 0 {
+		return fmt.Errorf("Invalid maximum resume attempts, must not be negative")
+	}
+
+	durations := map[string]time.Duration{
+		"retry initial delay":     opts.RetryInitialDelay,
+		"retry maximum delay":     opts.RetryMaxDelay,
+		"adaptive latency target": opts.AdaptiveLatencyTarget,
+		"stats interval":          opts.StatsInterval,
+		"resume delay":            opts.ResumeDelay,
+		"listing cache TTL":       opts.ListingCacheTTL,
+	}
+
+	for label, d := range durations {
+
+		if d < 0 {
+			return fmt.Errorf("Invalid %s, must not be negative", label)
+		}
+	}
+
+	rates := map[string]float64{
+		"maximum listing requests per second": opts.MaxListRPS,
+		"maximum get requests per second":     opts.MaxGetRPS,
+		"maximum bytes per second":            opts.MaxBytesPerSecond,
+	}
+
+	for label, r := range rates {
+
+		if r < 0 {
+			return fmt.Errorf("Invalid %s, must not be negative", label)
+		}
+	}
+
+	if opts.CacheMaxBytes < 0 {
+		return fmt.Errorf("Invalid maximum cache size, must not be negative")
+	}
+
+	if opts.AdaptiveConcurrency {
+
+		min_get := defaultInt(opts.MinGetConcurrency, DEFAULT_MIN_GET_CONCURRENCY)
+		max_get := defaultInt(opts.MaxGetConcurrency, DEFAULT_MAX_GET_CONCURRENCY)
+		min_list := defaultInt(opts.MinListConcurrency, DEFAULT_MIN_LIST_CONCURRENCY)
+		max_list := defaultInt(opts.MaxListConcurrency, DEFAULT_MAX_LIST_CONCURRENCY)
+
+		if min_get < 1 || max_get < 1 || min_list < 1 || max_list < 1 {
+			return fmt.Errorf("Invalid concurrency bounds, must be greater than 0")
+		}
+
+		if min_get > max_get {
+			return fmt.Errorf("Invalid minimum get concurrency, must not be greater than maximum get concurrency")
+		}
+
+		if min_list > max_list {
+			return fmt.Errorf("Invalid minimum list concurrency, must not be greater than maximum list concurrency")
+		}
+	}
+
+	if (opts.CacheDir != "" || opts.ListingCacheURI != "") && opts.BucketURI == "" {
+		return fmt.Errorf("Missing bucket URI, required to key cached objects and listings")
+	}
+
+	return nil
+}
+
+// NewBucketIteratorWithBucket returns a new `BucketIterator` which iterates 'bucket' using 'opts' (or, if nil,
+// `DefaultBucketIteratorOptions`). The caller owns 'bucket': it is not closed when the iterator is closed.
+func NewBucketIteratorWithBucket(ctx context.Context, bucket *blob.Bucket, opts *BucketIteratorOptions) (iterate.Iterator, error) {
+
+	if bucket == nil {
+		return nil, fmt.Errorf("Missing bucket")
+	}
+
+	if opts == nil {
+		opts = DefaultBucketIteratorOptions()
+	}
+
+	return newBucketIteratorWithOptions(ctx, bucket, opts)
+}
+
+// newBucketIteratorWithOptions returns a new `BucketIterator` which iterates 'bucket' using 'opts'. The returned iterator
+// does not own 'bucket'.
+func newBucketIteratorWithOptions(ctx context.Context, bucket *blob.Bucket, opts *BucketIteratorOptions) (*BucketIterator, error) {
+
+	err := opts.Validate()
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid options, %w", err)
+	}
+
+	it := &BucketIterator{
+		bucket:                 bucket,
+		bucket_uri:             opts.BucketURI,
+		filters:                opts.Filters,
+		mode:                   opts.Mode,
+		source:                 opts.Source,
+		on_error:               opts.OnError,
+		dry_run:                opts.DryRun,
+		decompress:             opts.Decompress,
+		check_content_encoding: opts.CheckContentEncoding,
+		throughput:             newThroughputMeter(),
+		tracer:                 newNoopTracer(),
+		hooks:                  opts.Hooks,
+		stats:                  newIteratorStats(),
+		with_stats:             opts.WithStats,
+		stats_interval:         opts.StatsInterval,
+		stats_level:            opts.StatsLevel,
+		seen:                   int64(0),
+		iterating:              new(atomic.Bool),
+	}
+
+	if it.mode == "" {
+		it.mode = MODE_OBJECT
+	}
+
+	if it.source == "" {
+		it.source = SOURCE_LIST
+	}
+
+	if it.on_error == "" {
+		it.on_error = ON_ERROR_FAIL
+	}
+
+	if it.stats_interval == 0 {
+		it.stats_interval = DEFAULT_STATS_INTERVAL
+	}
+
+	if it.source == SOURCE_META {
+		it.meta_filters = newMetaFilters(opts.MetaPlacetypes, opts.MetaIsCurrent, opts.MetaLastModifiedMin, opts.MetaLastModifiedMax)
+	}
+
+	retry := &retryPolicy{
+		max_retries:   opts.MaxRetries,
+		initial_delay: opts.RetryInitialDelay,
+		max_delay:     opts.RetryMaxDelay,
+	}
+
+	if retry.initial_delay == 0 {
+		retry.initial_delay = DEFAULT_RETRY_INITIAL_DELAY
+	}
+
+	if retry.max_delay == 0 {
+		retry.max_delay = DEFAULT_RETRY_MAX_DELAY
+	}
+
+	it.retry = retry
+
+	if opts.MaxListRPS > 0 {
+		it.list_limiter = newRateLimiter(opts.MaxListRPS)
+	}
+
+	if opts.MaxGetRPS > 0 {
+		it.get_limiter = newRateLimiter(opts.MaxGetRPS)
+	}
+
+	if opts.MaxBytesPerSecond > 0 {
+		it.bandwidth_limiter = newRateLimiter(opts.MaxBytesPerSecond)
+	}
+
+	if opts.AdaptiveConcurrency {
+
+		min_get := defaultInt(opts.MinGetConcurrency, DEFAULT_MIN_GET_CONCURRENCY)
+		max_get := defaultInt(opts.MaxGetConcurrency, DEFAULT_MAX_GET_CONCURRENCY)
+		min_list := defaultInt(opts.MinListConcurrency, DEFAULT_MIN_LIST_CONCURRENCY)
+		max_list := defaultInt(opts.MaxListConcurrency, DEFAULT_MAX_LIST_CONCURRENCY)
+
+		latency_target := opts.AdaptiveLatencyTarget
+
+		if latency_target == 0 {
+			latency_target = DEFAULT_ADAPTIVE_LATENCY_TARGET
+		}
+
+		it.get_concurrency = newAdaptiveLimiter("get", min_get, max_get, latency_target)
+		it.list_concurrency = newAdaptiveLimiter("list", min_list, max_list, latency_target)
+	}
+
+	if opts.Resume {
+
+		it.resume = true
+		it.resume_max_attempts = defaultInt(opts.ResumeMaxAttempts, DEFAULT_RESUME_MAX_ATTEMPTS)
+		it.resume_delay = opts.ResumeDelay
+
+		if it.resume_delay == 0 {
+			it.resume_delay = DEFAULT_RESUME_DELAY
+		}
+	}
+
+	mux := opts.URLMux
+
+	if mux == nil {
+		mux = blob.DefaultURLMux()
+	}
+
+	if it.on_error == ON_ERROR_COLLECT || it.source == SOURCE_DEAD_LETTER {
+
+		dead_letter_key := opts.DeadLetterKey
+
+		if dead_letter_key == "" {
+			dead_letter_key = DEFAULT_DEAD_LETTER_KEY
+		}
+
+		dead_letters, err := newDeadLetters(ctx, mux, opts.DeadLetterURI, dead_letter_key)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create dead-letter report, %w", err)
+		}
+
+		it.dead_letters = dead_letters
+	}
+
+	if opts.CacheDir != "" {
+
+		max_bytes := opts.CacheMaxBytes
+
+		if max_bytes == 0 {
+			max_bytes = DEFAULT_CACHE_MAX_BYTES
+		}
+
+		cache, err := newObjectCache(opts.CacheDir, max_bytes)
+
+		if err != nil {
+			return it.closeOnError(fmt.Errorf("Failed to create object cache, %w", err))
+		}
+
+		it.cache = cache
+	}
+
+	if opts.ListingCacheURI != "" {
+
+		ttl := opts.ListingCacheTTL
+
+		if ttl == 0 {
+			ttl = DEFAULT_LISTING_CACHE_TTL
+		}
+
+		listing_cache, err := newListingCache(ctx, mux, opts.ListingCacheURI, ttl)
+
+		if err != nil {
+			return it.closeOnError(fmt.Errorf("Failed to create listing cache, %w", err))
+		}
+
+		it.listing_cache = listing_cache
+	}
+
+	scheme := ""
+
+	if opts.BucketURI != "" {
+
+		u, err := url.Parse(opts.BucketURI)
+
+		if err != nil {
+			return it.closeOnError(fmt.Errorf("Failed to parse bucket URI, %w", scrubError(err, opts.BucketURI)))
+		}
+
+		scheme = u.Scheme
+	}
+
+	var metrics *iteratorMetrics
+
+	if opts.MeterProvider != nil {
+		metrics, err = newIteratorMetrics(opts.MeterProvider, scheme)
+	} else {
+		metrics, err = newNoopIteratorMetrics(scheme)
+	}
+
+	if err != nil {
+		return it.closeOnError(fmt.Errorf("Failed to create iterator metrics, %w", err))
+	}
+
+	it.metrics = metrics
+
+	if opts.TracerProvider != nil {
+		it.SetTracerProvider(opts.TracerProvider)
+	}
+
+	it.SetLogger(opts.Logger)
+
+	iterator_q := url.Values{}
+
+	for k, v := range opts.IteratorParameters {
+
+		if !strings.HasPrefix(k, "_") {
+			continue
+		}
+
+		// Failed URIs are resumed by the bucket iterator itself so the wrapper must not start them again from the beginning
+
+		if it.resume && slices.Contains(resume_params, k) {
+			continue
+		}
+
+		iterator_q[k] = v
+	}
+
+	iterator_uri := url.URL{
+		Scheme:   CATCHALL_SCHEME,
+		RawQuery: iterator_q.Encode(),
+	}
+
+	concurrent_it, err := iterate.NewConcurrentIterator(ctx, iterator_uri.String(), &objectIterator{it: it})
+
+	if err != nil {
+		return it.closeOnError(fmt.Errorf("Failed to create concurrent iterator, %w", err))
+	}
+
+	it.iterator = concurrent_it
+
+	return it, nil
+}
+
+// closeOnError closes the resources opened by 'it' (but not its bucket, which is closed by the caller of
+// `newBucketIteratorWithOptions`) after it failed to be created and returns 'err'.
+func (it *BucketIterator) closeOnError(err error) (*BucketIterator, error) {
+
+	close_err := it.closeResources()
+
+	if close_err != nil {
+		slog.Default().Warn("Failed to close iterator resources", "error", close_err)
+	}
+
+	return nil, err
+}
+
+// defaultString returns 'v' or, if it is empty, 'default_v'.
+func defaultString(v string, default_v string) string {
+
+	if v == "" {
+		return default_v
+	}
+
+	return v
+}
+
+// defaultInt returns 'v' or, if it is 0, 'default_v'.
+func defaultInt(v int, default_v int) int {
+
+	if v == 0 {
+		return default_v
+	}
+
+	return v
+}
+
+// NewBucketIteratorOptionsFromQuery returns a new `BucketIteratorOptions` instance derived from the parameters in 'q'
+// which are described in `NewBucketIterator`. An error is returned if a parameter can not be parsed. Parameters which are
+// not bucket iterator parameters are ignored since they may be `gocloud.dev/blob` driver parameters, which depend on the
+// bucket's scheme; `NewBucketIterator` rejects any parameter which is neither. The options are not validated; use the
+// `Validate` method to do so.
+func NewBucketIteratorOptionsFromQuery(ctx context.Context, q url.Values) (*BucketIteratorOptions, error) {
+
+	opts := DefaultBucketIteratorOptions()
+
+	f, err := filters.NewQueryFiltersFromQuery(ctx, q)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create filters from query, %w", err)
+	}
+
+	opts.Filters = f
+
+	for k, v := range q {
+
+		if !strings.HasPrefix(k, "_") {
+			continue
+		}
+
+		if opts.IteratorParameters == nil {
+			opts.IteratorParameters = url.Values{}
+		}
+
+		opts.IteratorParameters[k] = v
+	}
+
+	if q.Has("mode") {
+		opts.Mode = q.Get("mode")
+	}
+
+	if q.Has("source") {
+		opts.Source = q.Get("source")
+	}
+
+	opts.MetaPlacetypes = q["meta_placetype"]
+
+	for _, v := range q["meta_is_current"] {
+
+		i, err := strconv.Atoi(v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'meta_is_current' parameter, %w", err)
+		}
+
+		opts.MetaIsCurrent = append(opts.MetaIsCurrent, i)
+	}
+
+	if q.Has("meta_lastmodified_min") {
+
+		v, err := strconv.ParseInt(q.Get("meta_lastmodified_min"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'meta_lastmodified_min' parameter, %w", err)
+		}
+
+		opts.MetaLastModifiedMin = v
+	}
+
+	if q.Has("meta_lastmodified_max") {
+
+		v, err := strconv.ParseInt(q.Get("meta_lastmodified_max"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'meta_lastmodified_max' parameter, %w", err)
+		}
+
+		opts.MetaLastModifiedMax = v
+	}
+
+	bools := map[string]*bool{
+		"dry_run":                &opts.DryRun,
+		"decompress":             &opts.Decompress,
+		"check_content_encoding": &opts.CheckContentEncoding,
+		"adaptive_concurrency":   &opts.AdaptiveConcurrency,
+		"_with_stats":            &opts.WithStats,
+	}
+
+	for k, ptr := range bools {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		v, err := strconv.ParseBool(q.Get(k))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '%s' parameter, %w", k, err)
+		}
+
+		*ptr = v
+	}
+
+	if q.Has("max_retries") {
+
+		v, err := strconv.Atoi(q.Get("max_retries"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'max_retries' parameter, %w", err)
+		}
+
+		opts.MaxRetries = v
+	}
+
+	millis := map[string]*time.Duration{
+		"retry_initial_delay":     &opts.RetryInitialDelay,
+		"retry_max_delay":         &opts.RetryMaxDelay,
+		"adaptive_latency_target": &opts.AdaptiveLatencyTarget,
+	}
+
+	for k, ptr := range millis {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		v, err := strconv.Atoi(q.Get(k))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '%s' parameter, %w", k, err)
+		}
+
+		*ptr = time.Duration(v) * time.Millisecond
+	}
+
+	rates := map[string]*float64{
+		"max_list_rps":         &opts.MaxListRPS,
+		"max_get_rps":          &opts.MaxGetRPS,
+		"max_bytes_per_second": &opts.MaxBytesPerSecond,
+	}
+
+	for k, ptr := range rates {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(q.Get(k), 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '%s' parameter, %w", k, err)
+		}
+
+		if v <= 0 {
+			return nil, fmt.Errorf("Invalid '%s' parameter, must be greater than 0", k)
+		}
+
+		*ptr = v
+	}
+
+	bounds := map[string]*int{
+		"min_get_concurrency":  &opts.MinGetConcurrency,
+		"max_get_concurrency":  &opts.MaxGetConcurrency,
+		"min_list_concurrency": &opts.MinListConcurrency,
+		"max_list_concurrency": &opts.MaxListConcurrency,
+	}
+
+	for k, ptr := range bounds {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		v, err := strconv.Atoi(q.Get(k))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '%s' parameter, %w", k, err)
+		}
+
+		if v < 1 {
+			return nil, fmt.Errorf("Invalid '%s' parameter, must be greater than 0", k)
+		}
+
+		*ptr = v
+	}
+
+	// The stats parameters are shared with (and have the same semantics as) the whosonfirst/go-whosonfirst-iterate/v3
+	// package which logs its own, bucket-agnostic, stats.
+
+	if q.Has("_stats_interval") {
+
+		v, err := strconv.Atoi(q.Get("_stats_interval"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '_stats_interval' parameter, %w", err)
+		}
+
+		if v <= 0 {
+			return nil, fmt.Errorf("Invalid '_stats_interval' parameter, must be greater than 0")
+		}
+
+		opts.StatsInterval = time.Duration(v) * time.Second
+	}
+
+	if q.Has("_stats_level") {
+
+		switch strings.ToUpper(q.Get("_stats_level")) {
+		case "DEBUG":
+			opts.StatsLevel = slog.LevelDebug
+		case "INFO":
+			opts.StatsLevel = slog.LevelInfo
+		case "WARN":
+			opts.StatsLevel = slog.LevelWarn
+		case "ERROR":
+			opts.StatsLevel = slog.LevelError
+		default:
+			return nil, fmt.Errorf("Invalid or unsupported '_stats_level' parameter")
+		}
+	}
+
+	// Resuming replaces the retry logic in the whosonfirst/go-whosonfirst-iterate/v3 package, which starts failed URIs
+	// from the beginning, so it is enabled by default when that is and uses the same parameters.
+
+	if q.Has("_retry") {
+
+		v, err := strconv.ParseBool(q.Get("_retry"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '_retry' parameter, %w", err)
+		}
+
+		opts.Resume = v
+
+		if v && q.Has("_max_retries") {
+
+			v, err := strconv.Atoi(q.Get("_max_retries"))
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse '_max_retries' parameter, %w", err)
+			}
+
+			if v < 1 {
+				return nil, fmt.Errorf("Invalid '_max_retries' parameter, must be greater than 0")
+			}
+
+			opts.ResumeMaxAttempts = v
+		}
+
+		if v && q.Has("_retry_after") {
+
+			v, err := strconv.Atoi(q.Get("_retry_after"))
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse '_retry_after' parameter, %w", err)
+			}
+
+			if v <= 0 {
+				return nil, fmt.Errorf("Invalid '_retry_after' parameter, must be greater than 0")
+			}
+
+			opts.ResumeDelay = time.Duration(v) * time.Second
+		}
+	}
+
+	if q.Has("resume") {
+
+		v, err := strconv.ParseBool(q.Get("resume"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'resume' parameter, %w", err)
+		}
+
+		opts.Resume = v
+	}
+
+	if q.Has("on_error") {
+		opts.OnError = q.Get("on_error")
+	}
+
+	opts.DeadLetterURI = q.Get("dead_letter")
+
+	if q.Has("dead_letter_key") {
+		opts.DeadLetterKey = q.Get("dead_letter_key")
+	}
+
+	opts.CacheDir = q.Get("cache_dir")
+
+	if q.Has("cache_max_bytes") {
+
+		v, err := strconv.ParseInt(q.Get("cache_max_bytes"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'cache_max_bytes' parameter, %w", err)
+		}
+
+		opts.CacheMaxBytes = v
+	}
+
+	opts.ListingCacheURI = q.Get("listing_cache")
+
+	if q.Has("listing_cache_ttl") {
+
+		v, err := strconv.Atoi(q.Get("listing_cache_ttl"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'listing_cache_ttl' parameter, %w", err)
+		}
+
+		opts.ListingCacheTTL = time.Duration(v) * time.Second
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,422 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func TestNewBucketIteratorWithBucket(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	b, err := blob.OpenBucket(ctx, fmt.Sprintf("file://%s", abs_path))
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer b.Close()
+
+	prefixed := blob.PrefixedBucket(b, "174/")
+	defer prefixed.Close()
+
+	opts := DefaultBucketIteratorOptions()
+	opts.WithStats = false
+
+	it, err := NewBucketIteratorWithBucket(ctx, prefixed, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != 3 {
+		t.Fatalf("Unexpected count: %d", count)
+	}
+
+	err = it.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close iterator, %v", err)
+	}
+
+	// The caller owns the bucket so it should still be usable after the iterator is closed
+
+	exists, err := prefixed.Exists(ctx, "612/434/7/1746124347.geojson")
+
+	if err != nil {
+		t.Fatalf("Failed to use bucket after closing iterator, %v", err)
+	}
+
+	if !exists {
+		t.Fatalf("Expected key to exist")
+	}
+}
+
+func TestNewBucketIteratorWithBucketInvalidOptions(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	b, err := blob.OpenBucket(ctx, fmt.Sprintf("file://%s", abs_path))
+
+	if err != nil {
+		t.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer b.Close()
+
+	_, err = NewBucketIteratorWithBucket(ctx, nil, nil)
+
+	if err == nil {
+		t.Fatalf("Expected missing bucket to fail")
+	}
+
+	tests := []*BucketIteratorOptions{
+		{Mode: "bogus"},
+		{Source: SOURCE_META, Mode: MODE_ARCHIVE},
+		{OnError: ON_ERROR_COLLECT},
+		{CacheDir: t.TempDir()},
+	}
+
+	for i, opts := range tests {
+
+		_, err := NewBucketIteratorWithBucket(ctx, b, opts)
+
+		if err == nil {
+			t.Fatalf("Expected options %d to fail", i)
+		}
+	}
+}
+
+func TestNewBucketIteratorWithBucketCloseOnError(t *testing.T) {
+
+	ctx := context.Background()
+
+	cache_file := filepath.Join(t.TempDir(), "cache")
+
+	err := os.WriteFile(cache_file, []byte("not a directory"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write cache file, %v", err)
+	}
+
+	tests := map[string]func(opts *BucketIteratorOptions){
+		"object cache": func(opts *BucketIteratorOptions) {
+			opts.CacheDir = filepath.Join(cache_file, "objects")
+		},
+		"listing cache": func(opts *BucketIteratorOptions) {
+			opts.ListingCacheURI = "bogus://"
+		},
+	}
+
+	for label, configure := range tests {
+
+		b := newFakeBucket(nil)
+		dead_letter_b := newFakeBucket(nil)
+
+		mux := new(blob.URLMux)
+		mux.RegisterBucket("fake", &fakeURLOpener{bucket: dead_letter_b})
+
+		opts := DefaultBucketIteratorOptions()
+		opts.URLMux = mux
+		opts.BucketURI = "fake://"
+		opts.OnError = ON_ERROR_COLLECT
+		opts.DeadLetterURI = "fake://"
+
+		configure(opts)
+
+		_, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(b), opts)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", label)
+		}
+
+		if !dead_letter_b.Closed() {
+			t.Fatalf("Expected dead-letter bucket to be closed after %s failed", label)
+		}
+
+		// The caller owns the bucket being iterated
+
+		if b.Closed() {
+			t.Fatalf("Did not expect bucket to be closed after %s failed", label)
+		}
+	}
+}
+
+func TestNewBucketIteratorOptionsFromQuery(t *testing.T) {
+
+	ctx := context.Background()
+
+	q, err := url.ParseQuery("mode=geojsonl&max_retries=5&retry_initial_delay=250&adaptive_concurrency=true&max_get_concurrency=4&meta_is_current=1&_with_stats=false&region=us-east-1")
+
+	if err != nil {
+		t.Fatalf("Failed to parse query, %v", err)
+	}
+
+	opts, err := NewBucketIteratorOptionsFromQuery(ctx, q)
+
+	if err != nil {
+		t.Fatalf("Failed to derive options, %v", err)
+	}
+
+	if opts.Mode != MODE_GEOJSONL {
+		t.Fatalf("Unexpected mode: %s", opts.Mode)
+	}
+
+	if opts.Source != SOURCE_LIST {
+		t.Fatalf("Unexpected source: %s", opts.Source)
+	}
+
+	if opts.MaxRetries != 5 {
+		t.Fatalf("Unexpected max retries: %d", opts.MaxRetries)
+	}
+
+	if opts.RetryInitialDelay != 250*time.Millisecond {
+		t.Fatalf("Unexpected retry initial delay: %v", opts.RetryInitialDelay)
+	}
+
+	if !opts.AdaptiveConcurrency || opts.MaxGetConcurrency != 4 || opts.MinGetConcurrency != DEFAULT_MIN_GET_CONCURRENCY {
+		t.Fatalf("Unexpected concurrency options: %v %d %d", opts.AdaptiveConcurrency, opts.MinGetConcurrency, opts.MaxGetConcurrency)
+	}
+
+	if len(opts.MetaIsCurrent) != 1 || opts.MetaIsCurrent[0] != 1 {
+		t.Fatalf("Unexpected meta is current: %v", opts.MetaIsCurrent)
+	}
+
+	if opts.WithStats {
+		t.Fatalf("Expected stats to be disabled")
+	}
+
+	err = opts.Validate()
+
+	if err != nil {
+		t.Fatalf("Expected options to be valid, %v", err)
+	}
+}
+
+func TestBucketIteratorOptionsValidate(t *testing.T) {
+
+	tests := []*BucketIteratorOptions{
+		{Mode: "bogus"},
+		{Source: "bogus"},
+		{OnError: "bogus"},
+		{Source: SOURCE_DEAD_LETTER},
+		{MaxRetries: -1},
+		{RetryMaxDelay: -1 * time.Second},
+		{MaxGetRPS: -1},
+		{AdaptiveConcurrency: true, MinGetConcurrency: 8, MaxGetConcurrency: 4},
+		{AdaptiveConcurrency: true, MinListConcurrency: -1},
+		{MetaLastModifiedMin: 10, MetaLastModifiedMax: 5},
+		{ListingCacheURI: "mem://"},
+	}
+
+	for i, opts := range tests {
+
+		err := opts.Validate()
+
+		if err == nil {
+			t.Fatalf("Expected options %d to be invalid", i)
+		}
+	}
+
+	valid := []*BucketIteratorOptions{
+		{},
+		DefaultBucketIteratorOptions(),
+		{AdaptiveConcurrency: true, MaxGetConcurrency: 4},
+	}
+
+	for i, opts := range valid {
+
+		err := opts.Validate()
+
+		if err != nil {
+			t.Fatalf("Expected options %d to be valid, %v", i, err)
+		}
+	}
+}
+
+func TestUnknownBucketIteratorParameters(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// Misspellings of iterator parameters are rejected before the bucket is opened
+
+	misspelled := map[string]string{
+		"mod=archive":          "mode",
+		"decompres=true":       "decompress",
+		"max-retries=2":        "max_retries",
+		"Dead_Letter=mem://":   "dead_letter",
+		"listing_cach=mem://":  "listing_cache",
+		"adaptive_concurency=": "adaptive_concurrency",
+	}
+
+	for query, expected := range misspelled {
+
+		_, err := NewBucketIterator(ctx, fmt.Sprintf("bucket-file://%s?%s", abs_path, query))
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to be rejected", query)
+		}
+
+		if !strings.Contains(err.Error(), fmt.Sprintf("did you mean '%s'", expected)) {
+			t.Fatalf("Expected error for '%s' to suggest '%s', %v", query, expected, err)
+		}
+
+		if !strings.Contains(err.Error(), "valid bucket iterator parameters are: ") {
+			t.Fatalf("Expected error for '%s' to list valid parameters, %v", query, err)
+		}
+	}
+
+	// Parameters which are not iterator or driver parameters are rejected, even if they do not look like a misspelling
+
+	for _, query := range []string{"flavour=vanilla", "code=1", "node=1", "_bogus=1", "region=us-east-1"} {
+
+		_, err = NewBucketIterator(ctx, fmt.Sprintf("bucket-file://%s?%s", abs_path, query))
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to be rejected", query)
+		}
+
+		k := strings.Split(query, "=")[0]
+
+		if !strings.Contains(err.Error(), fmt.Sprintf("'%s'", k)) {
+			t.Fatalf("Expected error to name the unknown parameter '%s', %v", k, err)
+		}
+
+		if !strings.Contains(err.Error(), "valid bucket iterator parameters are: ") || !strings.Contains(err.Error(), "valid 'file' driver parameters are: ") {
+			t.Fatalf("Expected error for '%s' to list valid parameters, %v", query, err)
+		}
+	}
+
+	// Valid driver parameters are passed through
+
+	it, err := NewBucketIterator(ctx, fmt.Sprintf("bucket-file://%s?no_tmp_dir=true", abs_path))
+
+	if err != nil {
+		t.Fatalf("Expected driver parameter to be passed through, %v", err)
+	}
+
+	it.Close()
+}
+
+func TestRegisterDriverParameters(t *testing.T) {
+
+	q, err := url.ParseQuery("mode=object&endpoint=internal&_dedupe=true")
+
+	if err != nil {
+		t.Fatalf("Failed to parse query, %v", err)
+	}
+
+	// Parameters for schemes without any registered driver parameters are passed to the driver
+
+	err = checkQueryParameters(q, "test-params")
+
+	if err != nil {
+		t.Fatalf("Expected parameters for unregistered scheme to be accepted, %v", err)
+	}
+
+	// Except for unknown "_"-prefixed parameters which are never passed to the driver
+
+	q.Set("_dedup", "true")
+
+	err = checkQueryParameters(q, "test-params")
+
+	if err == nil {
+		t.Fatalf("Expected unknown '_' parameter for unregistered scheme to be rejected")
+	}
+
+	if !strings.Contains(err.Error(), "'_dedup' (did you mean '_dedupe'?)") || !strings.Contains(err.Error(), "no driver parameters are registered for the 'test-params' scheme") {
+		t.Fatalf("Unexpected error, %v", err)
+	}
+
+	q.Del("_dedup")
+
+	RegisterDriverParameters("test-params", "region")
+
+	err = checkQueryParameters(q, "test-params")
+
+	if err == nil || !strings.Contains(err.Error(), "'endpoint'") {
+		t.Fatalf("Expected parameters not registered for the scheme to be rejected, %v", err)
+	}
+
+	RegisterDriverParameters("test-params", "region", "endpoint")
+
+	err = checkQueryParameters(q, "test-params")
+
+	if err != nil {
+		t.Fatalf("Expected registered driver parameters to be accepted, %v", err)
+	}
+}
+
+func TestCheckQueryParametersS3(t *testing.T) {
+
+	// Legacy (AWS SDK v1) parameters are still accepted by gocloud.dev/blob/s3blob
+
+	for _, raw := range []string{
+		"region=us-east-1&endpoint=http://localhost:9000&s3ForcePathStyle=true&disableSSL=true",
+		"region=us-east-1&s3_force_path_style=true&disable_ssl=true&awssdk=v1",
+		"region=us-east-1&use_path_style=true&disable_https=true&ssetype=aws:kms&kmskeyid=abc&accelerate=false&anonymous=true",
+	} {
+
+		q, err := url.ParseQuery(raw)
+
+		if err != nil {
+			t.Fatalf("Failed to parse query, %v", err)
+		}
+
+		err = checkQueryParameters(q, "s3")
+
+		if err != nil {
+			t.Fatalf("Expected '%s' to be accepted, %v", raw, err)
+		}
+	}
+
+	q, err := url.ParseQuery("region=us-east-1&use_legacy_list=true")
+
+	if err != nil {
+		t.Fatalf("Failed to parse query, %v", err)
+	}
+
+	err = checkQueryParameters(q, "s3")
+
+	if err == nil {
+		t.Fatalf("Expected 'use_legacy_list' to be rejected")
+	}
+}
@@ -0,0 +1,247 @@
+package bucket
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// reserved_params is the list of "_"-prefixed query parameters defined by the `whosonfirst/go-whosonfirst-iterate/v3`
+// package which are applied by the concurrent iterator wrapping `BucketIterator`.
+var reserved_params = []string{
+	"_max_procs",
+	"_include",
+	"_exclude",
+	"_exclude_alt",
+	"_dedupe",
+	"_retry",
+	"_max_retries",
+	"_retry_after",
+	"_with_stats",
+	"_stats_interval",
+	"_stats_level",
+}
+
+var driver_params_mu = new(sync.RWMutex)
+
+// driver_params maps `gocloud.dev/blob` schemes to the query parameters accepted by their drivers.
+var driver_params = map[string][]string{
+	"file": {
+		"metadata",
+		"create_dir",
+		"dir_file_mode",
+		"no_tmp_dir",
+		"base_url",
+		"secret_key_path",
+	},
+	"mem": {},
+	// The "s3" parameters are those handled by gocloud.dev/blob/s3blob and gocloud.dev/aws (for both
+	// versions of the AWS SDK) including their legacy aliases.
+	"s3": {
+		"ssetype",
+		"kmskeyid",
+		"accelerate",
+		"use_path_style",
+		"s3ForcePathStyle",
+		"s3_force_path_style",
+		"disable_https",
+		"disable_ssl",
+		"disableSSL",
+		"region",
+		"endpoint",
+		"hostname_immutable",
+		"profile",
+		"dualstack",
+		"fips",
+		"rate_limiter_capacity",
+		"anonymous",
+		"awssdk",
+	},
+	"gs": {
+		"access_id",
+		"private_key_path",
+		"anonymous",
+	},
+	"azblob": {
+		"domain",
+		"protocol",
+		"cdn",
+		"localemu",
+		"storage_account",
+	},
+}
+
+// RegisterDriverParameters registers 'params' as the query parameters accepted by the `gocloud.dev/blob` driver for 'scheme',
+// replacing any parameters already registered for it. Bucket iterator URIs may only contain bucket iterator parameters,
+// "_"-prefixed `whosonfirst/go-whosonfirst-iterate/v3` parameters and the driver parameters registered for their scheme.
+// Parameters for the "file", "mem", "s3", "gs" and "azblob" schemes are registered by default. If no parameters are
+// registered for a scheme (for example one registered with a custom `blob.URLMux` instance) any parameters which are not
+// "_"-prefixed are passed to its driver without being checked.
+func RegisterDriverParameters(scheme string, params ...string) {
+
+	driver_params_mu.Lock()
+	defer driver_params_mu.Unlock()
+
+	driver_params[scheme] = slices.Clone(params)
+}
+
+// driverParameters returns the query parameters registered for the `gocloud.dev/blob` driver for 'scheme' and a boolean
+// value indicating whether any parameters (including none at all) have been registered.
+func driverParameters(scheme string) ([]string, bool) {
+
+	driver_params_mu.RLock()
+	defer driver_params_mu.RUnlock()
+
+	params, ok := driver_params[scheme]
+	return slices.Clone(params), ok
+}
+
+// unknownQueryParameters returns the parameters in 'q' which are not bucket iterator parameters, `whosonfirst/go-whosonfirst-iterate/v3`
+// parameters or parameters accepted by the `gocloud.dev/blob` driver for 'scheme'. If no driver parameters are registered
+// for 'scheme' only unknown "_"-prefixed parameters are returned.
+func unknownQueryParameters(q url.Values, scheme string) []string {
+
+	params, registered := driverParameters(scheme)
+	unknown := make([]string, 0)
+
+	for k := range q {
+
+		if slices.Contains(iterator_params, k) || slices.Contains(reserved_params, k) || slices.Contains(params, k) {
+			continue
+		}
+
+		if !registered && !strings.HasPrefix(k, "_") {
+			continue
+		}
+
+		unknown = append(unknown, k)
+	}
+
+	slices.Sort(unknown)
+	return unknown
+}
+
+// checkQueryParameters returns an error naming the parameters in 'q' which are not bucket iterator parameters,
+// `whosonfirst/go-whosonfirst-iterate/v3` parameters or parameters accepted by the `gocloud.dev/blob` driver for
+// 'scheme', along with the valid parameters and, if one of them looks like a misspelling, a suggestion.
+func checkQueryParameters(q url.Values, scheme string) error {
+
+	unknown := unknownQueryParameters(q, scheme)
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	hints := make([]string, len(unknown))
+
+	for i, k := range unknown {
+
+		hints[i] = fmt.Sprintf("'%s'", k)
+
+		p, ok := suggestParameter(k, scheme)
+
+		if ok {
+			hints[i] = fmt.Sprintf("'%s' (did you mean '%s'?)", k, p)
+		}
+	}
+
+	label := "parameter"
+
+	if len(unknown) > 1 {
+		label = "parameters"
+	}
+
+	return fmt.Errorf("Unknown %s %s, %s", label, strings.Join(hints, ", "), validParametersMessage(scheme))
+}
+
+// suggestParameter returns the valid parameter for 'scheme' that 'k' looks like a misspelling of and a boolean value
+// indicating whether there is one.
+func suggestParameter(k string, scheme string) (string, bool) {
+
+	params, _ := driverParameters(scheme)
+
+	candidates := slices.Concat(iterator_params, reserved_params, params)
+
+	for _, p := range candidates {
+
+		if looksLike(k, p) {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// validParametersMessage returns a message listing the valid bucket iterator, `whosonfirst/go-whosonfirst-iterate/v3`
+// and `gocloud.dev/blob` driver parameters for 'scheme'.
+func validParametersMessage(scheme string) string {
+
+	iter_params := slices.Sorted(slices.Values(iterator_params))
+	msg := fmt.Sprintf("valid bucket iterator parameters are: %s; valid whosonfirst/go-whosonfirst-iterate parameters are: %s", strings.Join(iter_params, ", "), strings.Join(reserved_params, ", "))
+
+	params, ok := driverParameters(scheme)
+
+	switch {
+	case !ok:
+		msg = fmt.Sprintf("%s; no driver parameters are registered for the '%s' scheme so other parameters are passed to its driver (see RegisterDriverParameters)", msg, scheme)
+	case len(params) == 0:
+		msg = fmt.Sprintf("%s; the '%s' driver does not accept any parameters", msg, scheme)
+	default:
+		slices.Sort(params)
+		msg = fmt.Sprintf("%s; valid '%s' driver parameters are: %s", msg, scheme, strings.Join(params, ", "))
+	}
+
+	return msg
+}
+
+// looksLike returns a boolean value indicating whether 'k' looks like a misspelling of 'p': they differ only in case,
+// punctuation ("-" instead of "_") or by at most two single-character edits (or one for short parameters).
+func looksLike(k string, p string) bool {
+
+	normalized := strings.ReplaceAll(strings.ToLower(k), "-", "_")
+
+	if normalized == p {
+		return true
+	}
+
+	max_distance := 2
+
+	if len(p) <= 6 {
+		max_distance = 1
+	}
+
+	return editDistance(normalized, p) <= max_distance
+}
+
+// editDistance returns the Levenshtein distance between 'a' and 'b'.
+func editDistance(a string, b string) int {
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,437 @@
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"sync"
+
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/blob"
+)
+
+// fetchedObject is an object to be processed by `iterateObjects` along with the records derived from it.
+type fetchedObject struct {
+	// obj is the object's listing.
+	obj *blob.ListObject
+	// span is the "bucket.object" span for the object which must be ended by the caller.
+	span trace.Span
+	// records yields the records derived from the object.
+	records iter.Seq2[*iterate.Record, error]
+}
+
+// recordResult is a record, or an error, derived from an object fetched by a worker.
+type recordResult struct {
+	rec *iterate.Record
+	err error
+}
+
+// pooledObject is the result of fetching an object in a worker, or an error deriving the objects to fetch.
+type pooledObject struct {
+	fetched *fetchedObject
+	results []*recordResult
+	err     error
+	// hooks are the hooks invoked while fetching the object, to be replayed before it is handed to the caller.
+	hooks []func()
+}
+
+// discard closes the bodies of the records in 'o' and ends its span. It is used for objects which were fetched but never
+// handed to the caller.
+func (o *pooledObject) discard() {
+
+	if o.fetched == nil {
+		return
+	}
+
+	for _, r := range o.results {
+
+		if r.rec != nil {
+			r.rec.Body.Close()
+		}
+	}
+
+	o.fetched.span.End()
+}
+
+// fetchObjects returns an `iter.Seq2[*fetchedObject, error]` for each object to be processed for 'uri' which was not
+// processed during a previous attempt, according to 'state' (which may be nil), or vetoed by the iterator's hooks.
+//
+// If adaptive concurrency is enabled, and the iterator's mode is `MODE_OBJECT`, objects are fetched ahead of the caller
+// by a pool of workers whose size is the current "get" concurrency limit. Each worker reads the body of the object it
+// fetched in to memory, releasing its slot as soon as it has done so. Objects are still yielded in the order they were
+// listed. Otherwise each object is opened as its records are iterated.
+func (it *BucketIterator) fetchObjects(ctx context.Context, uri string, state *resumeState) iter.Seq2[*fetchedObject, error] {
+
+	if it.get_concurrency != nil && it.mode == MODE_OBJECT && !it.dry_run {
+		return it.pooledObjects(ctx, uri, state)
+	}
+
+	return func(yield func(f *fetchedObject, err error) bool) {
+
+		for obj, err := range it.objects(ctx, uri) {
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if !it.acceptObject(ctx, uri, obj, state) {
+				continue
+			}
+
+			obj_ctx, obj_span := it.startSpan(ctx, "bucket.object", objectAttributes(obj)...)
+
+			f := &fetchedObject{
+				obj:     obj,
+				span:    obj_span,
+				records: it.objectRecords(obj_ctx, obj),
+			}
+
+			if !yield(f, nil) {
+				return
+			}
+		}
+	}
+}
+
+// pooledObjects returns an `iter.Seq2[*fetchedObject, error]` for each object to be processed for 'uri', fetching them
+// in a pool of workers sized by the iterator's "get" concurrency limit. See `fetchObjects` for details.
+//
+// Objects are listed, and accepted, on the caller's goroutine up to the limiter's maximum number of objects ahead of
+// the object being yielded. Each object is fetched in its own goroutine, once it has acquired a slot, and the hooks
+// invoked while fetching it are replayed on the caller's goroutine before it is yielded.
+func (it *BucketIterator) pooledObjects(ctx context.Context, uri string, state *resumeState) iter.Seq2[*fetchedObject, error] {
+
+	return func(yield func(f *fetchedObject, err error) bool) {
+
+		l := it.get_concurrency
+
+		// Record bodies may still be read after iteration stops so objects are fetched with 'ctx' but listing and
+		// waiting for slots stop as soon as 'list_ctx' is cancelled.
+
+		list_ctx, cancel := context.WithCancel(ctx)
+
+		next, stop := iter.Pull2(it.objects(list_ctx, uri))
+
+		// pending is the queue of objects being fetched, in the order they were listed.
+		pending := make([]chan *pooledObject, 0, l.max)
+		done := false
+
+		defer func() {
+
+			cancel()
+			stop()
+
+			for _, result_ch := range pending {
+				r := <-result_ch
+				r.discard()
+			}
+		}()
+
+		for {
+
+			for !done && len(pending) < l.max {
+
+				obj, err, ok := next()
+
+				if !ok {
+					done = true
+					break
+				}
+
+				if err != nil {
+
+					result_ch := make(chan *pooledObject, 1)
+					result_ch <- &pooledObject{err: err}
+
+					pending = append(pending, result_ch)
+					done = true
+					break
+				}
+
+				if !it.acceptObject(ctx, uri, obj, state) {
+					continue
+				}
+
+				pending = append(pending, it.startFetch(ctx, list_ctx, obj))
+			}
+
+			if len(pending) == 0 {
+				return
+			}
+
+			result_ch := pending[0]
+			pending = pending[1:]
+
+			r := <-result_ch
+
+			replayHooks(r.hooks)
+
+			if r.err != nil {
+				yield(nil, r.err)
+				return
+			}
+
+			if !yield(r.fetched, nil) {
+				return
+			}
+		}
+	}
+}
+
+// startFetch fetches 'obj' in a new goroutine, once it has acquired a slot in the iterator's "get" concurrency limiter,
+// returning a channel which receives the result. Waiting for a slot stops if 'list_ctx' is cancelled.
+func (it *BucketIterator) startFetch(ctx context.Context, list_ctx context.Context, obj *blob.ListObject) chan *pooledObject {
+
+	result_ch := make(chan *pooledObject, 1)
+
+	obj_ctx, obj_span := it.startSpan(ctx, "bucket.object", objectAttributes(obj)...)
+	obj_ctx, hooks := withDeferredHooks(obj_ctx)
+
+	go func() {
+
+		err := it.get_concurrency.Acquire(list_ctx)
+
+		if err != nil {
+			obj_span.End()
+			result_ch <- &pooledObject{err: err}
+			return
+		}
+
+		r := it.fetchObject(obj_ctx, obj, obj_span)
+		r.hooks = hooks.Drain()
+
+		result_ch <- r
+	}()
+
+	return result_ch
+}
+
+// fetchObject derives the records for 'obj' in a worker which holds a slot in the iterator's "get" concurrency limiter,
+// releasing it when it returns.
+// The bodies of the records are read in to memory, and the underlying readers closed, before the slot is released so
+// that the number of requests in flight is bounded by the limiter regardless of when, or whether, callers close them.
+func (it *BucketIterator) fetchObject(ctx context.Context, obj *blob.ListObject, span trace.Span) *pooledObject {
+
+	l := it.get_concurrency
+	defer l.Release()
+
+	results := make([]*recordResult, 0, 1)
+
+	for rec, err := range it.objectRecords(withHeldSlot(ctx, l), obj) {
+
+		if err == nil {
+			rec, err = bufferRecord(rec)
+		}
+
+		results = append(results, &recordResult{rec: rec, err: err})
+	}
+
+	records := func(yield func(rec *iterate.Record, err error) bool) {
+
+		for _, r := range results {
+
+			if !yield(r.rec, r.err) {
+				return
+			}
+		}
+	}
+
+	f := &fetchedObject{
+		obj:     obj,
+		span:    span,
+		records: records,
+	}
+
+	o := &pooledObject{
+		fetched: f,
+		results: results,
+	}
+
+	return o
+}
+
+// bufferRecord returns a copy of 'rec' whose body has been read in to memory, closing the original body. The compression
+// scheme of the original body, as reported by `RecordCompression`, is preserved.
+func bufferRecord(rec *iterate.Record) (*iterate.Record, error) {
+
+	defer rec.Body.Close()
+
+	body, err := io.ReadAll(rec.Body)
+
+	if err != nil {
+		return nil, newObjectError(OP_GET, rec.Path, fmt.Errorf("Failed to read %s, %w", rec.Path, err))
+	}
+
+	var rsc io.ReadSeekCloser
+
+	rsc, err = ioutil.NewReadSeekCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create ReadSeekCloser for %s, %w", rec.Path, err)
+	}
+
+	compression := RecordCompression(rec)
+
+	if compression != COMPRESSION_NONE {
+
+		rsc = &decompressedBody{
+			ReadSeekCloser: rsc,
+			compression:    compression,
+		}
+	}
+
+	return iterate.NewRecord(rec.Path, rsc), nil
+}
+
+// listResult is an object, or an error, derived from a listing.
+type listResult struct {
+	obj *blob.ListObject
+	err error
+	// hooks are the hooks invoked while listing the object, to be replayed before it is handed to the caller.
+	hooks []func()
+}
+
+// listEntry is an entry in the listing of a prefix, along with the (concurrent) listing of its contents if it is a "directory".
+type listEntry struct {
+	obj *blob.ListObject
+	err error
+	// contents yields the objects contained by 'obj' if it is a "directory" being listed concurrently.
+	contents chan *listResult
+}
+
+// listPrefixConcurrently returns an `iter.Seq2[*blob.ListObject, error]` for each object in the bucket whose key starts
+// with 'prefix', in lexicographic order. The "directories" immediately below 'prefix' are discovered by listing it with
+// the delimiter "/" and their contents are listed ahead of the caller, up to the iterator's current "list" concurrency
+// limit at a time.
+func (it *BucketIterator) listPrefixConcurrently(ctx context.Context, prefix string) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(obj *blob.ListObject, err error) bool) {
+
+		ctx, cancel := context.WithCancel(ctx)
+		wg := new(sync.WaitGroup)
+
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+
+		next, stop := iter.Pull2(it.listPrefix(ctx, prefix, "/"))
+		defer stop()
+
+		queue := make([]*listEntry, 0)
+		listing := 0
+		done := false
+
+		for {
+
+			for !done && len(queue) < LIST_PAGE_SIZE && listing < it.list_concurrency.Limit() {
+
+				obj, err, ok := next()
+
+				if !ok {
+					done = true
+					break
+				}
+
+				e := &listEntry{
+					obj: obj,
+					err: err,
+				}
+
+				if err != nil {
+					done = true
+				} else if obj.IsDir && matchesPrefix(strings.TrimSuffix(obj.Key, "/"), prefix) {
+					e.contents = it.listContents(ctx, wg, obj.Key)
+					listing += 1
+				}
+
+				queue = append(queue, e)
+			}
+
+			if len(queue) == 0 {
+				return
+			}
+
+			e := queue[0]
+			queue = queue[1:]
+
+			if e.err != nil {
+				yield(nil, e.err)
+				return
+			}
+
+			if e.contents == nil {
+
+				if !yield(e.obj, nil) {
+					return
+				}
+
+				continue
+			}
+
+			listing -= 1
+
+			for r := range e.contents {
+
+				replayHooks(r.hooks)
+
+				if !yield(r.obj, r.err) || r.err != nil {
+					return
+				}
+			}
+
+			// The listing stops early, without an error, if 'ctx' is cancelled
+
+			if ctx.Err() != nil {
+				yield(nil, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+// listContents lists all the objects whose key starts with 'prefix' in a new goroutine, added to 'wg', returning a channel
+// which receives them in lexicographic order and is closed when the listing is complete, fails or 'ctx' is cancelled.
+// The hooks invoked while listing are attached to the first result that follows them.
+func (it *BucketIterator) listContents(ctx context.Context, wg *sync.WaitGroup, prefix string) chan *listResult {
+
+	contents := make(chan *listResult, LIST_PAGE_SIZE)
+
+	list_ctx, hooks := withDeferredHooks(ctx)
+
+	wg.Add(1)
+
+	go func() {
+
+		defer wg.Done()
+		defer close(contents)
+
+		for obj, err := range it.listPrefix(list_ctx, prefix, "") {
+
+			r := &listResult{
+				obj:   obj,
+				err:   err,
+				hooks: hooks.Drain(),
+			}
+
+			select {
+			case contents <- r:
+				// pass
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return contents
+}
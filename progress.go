@@ -0,0 +1,199 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DEFAULT_PROGRESS_INTERVAL is the default interval at which progress is reported.
+const DEFAULT_PROGRESS_INTERVAL time.Duration = 5 * time.Second
+
+// ScanTotals are the totals derived from a pre-scan of the objects that will be processed for one or more URIs.
+type ScanTotals struct {
+	// Keys is the number of keys that will be processed.
+	Keys int64 `json:"keys"`
+	// Bytes is the combined size of the objects that will be processed. Objects which are not derived from a bucket
+	// listing (for example, when using the "meta" or "dead_letter" sources) do not have a size and are not included.
+	Bytes int64 `json:"bytes"`
+}
+
+// Scan derives the objects that will be processed for 'uris', without opening any of them, and returns the number of
+// keys and their combined size. Listings are read from, and written to, the iterator's listing cache if present.
+func (it *BucketIterator) Scan(ctx context.Context, uris ...string) (*ScanTotals, error) {
+
+	totals := new(ScanTotals)
+
+	for _, uri := range uris {
+
+		for obj, err := range it.objects(ctx, uri) {
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to scan '%s', %w", uri, err)
+			}
+
+			totals.Keys += 1
+			totals.Bytes += obj.Size
+		}
+	}
+
+	return totals, nil
+}
+
+// Progress is a snapshot of the progress of a `BucketIterator`.
+type Progress struct {
+	// Keys is the number of keys processed so far, including keys which were skipped and, in dry-run mode, keys
+	// whose objects were not opened. See `Stats.KeysProcessed`.
+	Keys int64 `json:"keys"`
+	// TotalKeys is the number of keys that will be processed, or 0 if unknown.
+	TotalKeys int64 `json:"total_keys"`
+	// Records is the number of records yielded so far.
+	Records int64 `json:"records"`
+	// Bytes is the number of bytes read from the bucket so far.
+	Bytes int64 `json:"bytes"`
+	// TotalBytes is the combined size of the objects that will be processed, or 0 if unknown.
+	TotalBytes int64 `json:"total_bytes"`
+	// RecordsPerSecond is the average number of records yielded per second.
+	RecordsPerSecond float64 `json:"records_per_second"`
+	// BytesPerSecond is the current combined throughput of all the object bodies being read.
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// Elapsed is the time since iteration started.
+	Elapsed time.Duration `json:"elapsed"`
+	// ETA is the estimated time remaining, or 0 if unknown. It is derived from the proportion of keys processed.
+	ETA time.Duration `json:"eta"`
+}
+
+// ProgressFunc is a function which is invoked periodically, and once more when reporting stops, by a `ProgressReporter`.
+type ProgressFunc func(ctx context.Context, p *Progress)
+
+// NewProgressWriter returns a `ProgressFunc` which writes a single line summarizing each progress report to 'wr'.
+func NewProgressWriter(wr io.Writer) ProgressFunc {
+
+	return func(ctx context.Context, p *Progress) {
+
+		keys := fmt.Sprintf("%d", p.Keys)
+
+		if p.TotalKeys > 0 {
+			keys = fmt.Sprintf("%d/%d (%.1f%%)", p.Keys, p.TotalKeys, float64(p.Keys)/float64(p.TotalKeys)*100)
+		}
+
+		eta := "unknown"
+
+		if p.ETA > 0 {
+			eta = p.ETA.Round(time.Second).String()
+		}
+
+		fmt.Fprintf(wr, "objects %s, records %d (%.1f/s), read %s (%s/s), elapsed %s, eta %s\n",
+			keys, p.Records, p.RecordsPerSecond, formatBytes(float64(p.Bytes)), formatBytes(p.BytesPerSecond), p.Elapsed.Round(time.Second), eta)
+	}
+}
+
+// formatBytes returns a human-readable representation of 'b' bytes.
+func formatBytes(b float64) string {
+
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+
+	for b >= 1024 && i < len(units)-1 {
+		b = b / 1024
+		i += 1
+	}
+
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
+
+// ProgressReporter periodically reports the progress of a `BucketIterator` to a `ProgressFunc`.
+type ProgressReporter struct {
+	iterator *BucketIterator
+	totals   *ScanTotals
+	interval time.Duration
+	report   ProgressFunc
+	done     chan bool
+	wg       *sync.WaitGroup
+	stop     *sync.Once
+}
+
+// NewProgressReporter returns a new `ProgressReporter` instance which reports the progress of 'it' to 'report' every
+// 'interval'. 'totals' are the results of a previous call to `Scan` and may be nil, in which case totals and ETAs are not reported.
+func NewProgressReporter(it *BucketIterator, totals *ScanTotals, interval time.Duration, report ProgressFunc) *ProgressReporter {
+
+	if totals == nil {
+		totals = new(ScanTotals)
+	}
+
+	r := &ProgressReporter{
+		iterator: it,
+		totals:   totals,
+		interval: interval,
+		report:   report,
+		done:     make(chan bool),
+		wg:       new(sync.WaitGroup),
+		stop:     new(sync.Once),
+	}
+
+	return r
+}
+
+// Progress returns a snapshot of the iterator's current progress.
+func (r *ProgressReporter) Progress() *Progress {
+
+	st := r.iterator.Stats()
+
+	p := &Progress{
+		Keys:           st.KeysProcessed,
+		TotalKeys:      r.totals.Keys,
+		Records:        st.RecordsYielded,
+		Bytes:          st.BytesRead,
+		TotalBytes:     r.totals.Bytes,
+		BytesPerSecond: st.BytesPerSecond,
+		Elapsed:        st.Elapsed,
+	}
+
+	if st.Elapsed > 0 {
+		p.RecordsPerSecond = float64(st.RecordsYielded) / st.Elapsed.Seconds()
+	}
+
+	if p.TotalKeys > 0 && p.Keys > 0 && p.Keys < p.TotalKeys {
+		remaining := float64(p.TotalKeys-p.Keys) / float64(p.Keys)
+		p.ETA = time.Duration(float64(st.Elapsed) * remaining)
+	}
+
+	return p
+}
+
+// Start starts reporting progress, in a separate goroutine, until `Stop` is called or 'ctx' is cancelled.
+func (r *ProgressReporter) Start(ctx context.Context) {
+
+	r.wg.Add(1)
+
+	go func() {
+
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.done:
+				return
+			case <-ticker.C:
+				r.report(ctx, r.Progress())
+			}
+		}
+	}()
+}
+
+// Stop stops reporting progress and reports the final progress once more.
+func (r *ProgressReporter) Stop(ctx context.Context) {
+
+	r.stop.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+		r.report(ctx, r.Progress())
+	})
+}
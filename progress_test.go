@@ -0,0 +1,137 @@
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucketIteratorProgress(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s", abs_path)
+
+	iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	it := iter.(*BucketIterator)
+
+	totals, err := it.Scan(ctx, ".")
+
+	if err != nil {
+		t.Fatalf("Failed to scan bucket, %v", err)
+	}
+
+	if totals.Keys != 37 {
+		t.Fatalf("Expected 37 keys, but got %d", totals.Keys)
+	}
+
+	if totals.Bytes == 0 {
+		t.Fatalf("Expected total bytes")
+	}
+
+	if it.Stats().ObjectsOpened != 0 {
+		t.Fatalf("Scan should not open any objects")
+	}
+
+	var buf bytes.Buffer
+	reports := 0
+
+	report := func(ctx context.Context, p *Progress) {
+		reports += 1
+		NewProgressWriter(&buf)(ctx, p)
+	}
+
+	reporter := NewProgressReporter(it, totals, time.Hour, report)
+	reporter.Start(ctx)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	reporter.Stop(ctx)
+
+	if reports != 1 {
+		t.Fatalf("Expected a single, final, progress report, but got %d", reports)
+	}
+
+	p := reporter.Progress()
+
+	if p.Keys != 37 || p.TotalKeys != 37 || p.Records != 37 {
+		t.Fatalf("Unexpected progress, %v", p)
+	}
+
+	if !strings.HasPrefix(buf.String(), "objects 37/37 (100.0%), records 37") {
+		t.Fatalf("Unexpected progress report, %s", buf.String())
+	}
+}
+
+func TestBucketIteratorProgressDryRun(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?dry_run=true", abs_path)
+
+	iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	it := iter.(*BucketIterator)
+
+	reporter := NewProgressReporter(it, nil, time.Hour, func(ctx context.Context, p *Progress) {})
+	reporter.Start(ctx)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	reporter.Stop(ctx)
+
+	// Objects are not opened in a dry run but every key is still processed
+
+	if it.Stats().ObjectsOpened != 0 {
+		t.Fatalf("Dry run should not open any objects")
+	}
+
+	p := reporter.Progress()
+
+	if p.Keys != 37 || p.Records != 37 {
+		t.Fatalf("Unexpected progress, %v", p)
+	}
+}
@@ -0,0 +1,97 @@
+package bucket
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token-bucket rate limiter which is safe to share across goroutines. Tokens are added at a rate of
+// 'rps' per second up to a maximum of 'burst' tokens.
+type rateLimiter struct {
+	// mu guards 'tokens' and 'last'.
+	mu *sync.Mutex
+	// rps is the number of tokens added to the bucket per second.
+	rps float64
+	// burst is the maximum number of tokens the bucket can hold.
+	burst float64
+	// tokens is the number of tokens currently in the bucket. It may be negative if callers have reserved tokens they are still waiting for.
+	tokens float64
+	// last is the time 'tokens' was last updated.
+	last time.Time
+}
+
+// newRateLimiter returns a new `rateLimiter` instance allowing 'rps' operations per second. The bucket starts full
+// and holds enough tokens for one second of operations (but never less than one).
+func newRateLimiter(rps float64) *rateLimiter {
+
+	burst := math.Max(1, math.Ceil(rps))
+
+	l := &rateLimiter{
+		mu:     new(sync.Mutex),
+		rps:    rps,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+
+	return l
+}
+
+// Wait blocks until a token is available or 'ctx' is cancelled, in which case the context's error is returned.
+// Calling Wait on a nil `rateLimiter` returns immediately.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until 'n' tokens are available or 'ctx' is cancelled, in which case the context's error is returned.
+// 'n' may be larger than the size of the bucket in which case the caller waits for the bucket to repay the difference.
+// Calling WaitN on a nil `rateLimiter` returns immediately.
+func (l *rateLimiter) WaitN(ctx context.Context, n float64) error {
+
+	if l == nil {
+		return nil
+	}
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	// Reserve the tokens, even if that leaves the bucket in debt, and then wait for the
+	// debt to be repaid. This keeps waiting callers in (roughly) the order they arrived.
+
+	l.mu.Lock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+(now.Sub(l.last).Seconds()*l.rps))
+	l.last = now
+	l.tokens -= n
+
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	delay := time.Duration((-l.tokens / l.rps) * float64(time.Second))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+
+		// Give back the tokens that were reserved but will never be used.
+
+		l.mu.Lock()
+		l.tokens += n
+		l.mu.Unlock()
+
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
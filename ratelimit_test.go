@@ -0,0 +1,120 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+
+	ctx := context.Background()
+
+	// 20 requests per second with a burst of 20 means 30 requests,
+	// shared across goroutines, should take at least half a second.
+
+	l := newRateLimiter(20)
+
+	wg := new(sync.WaitGroup)
+	t0 := time.Now()
+
+	for i := 0; i < 3; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+
+				err := l.Wait(ctx)
+
+				if err != nil {
+					t.Errorf("Failed to wait for rate limiter, %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if time.Since(t0) < 450*time.Millisecond {
+		t.Fatalf("Rate limiter did not limit requests, finished in %v", time.Since(t0))
+	}
+}
+
+func TestRateLimiterContext(t *testing.T) {
+
+	l := newRateLimiter(0.1)
+
+	err := l.Wait(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to wait for rate limiter, %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	t0 := time.Now()
+	err = l.Wait(ctx)
+
+	if err == nil {
+		t.Fatalf("Expected rate limiter to return context error")
+	}
+
+	if time.Since(t0) > time.Second {
+		t.Fatalf("Rate limiter did not respect context cancellation")
+	}
+}
+
+func TestBucketIteratorRateLimit(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?max_list_rps=1&max_get_rps=100", abs_path)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != 37 {
+		t.Fatalf("Expected 37 records, but counted %d", count)
+	}
+
+	for _, q := range []string{"max_list_rps=0", "max_get_rps=bogus"} {
+
+		_, err := NewBucketIterator(ctx, fmt.Sprintf("bucket-file://%s?%s", abs_path, q))
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", q)
+		}
+	}
+}
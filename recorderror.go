@@ -0,0 +1,92 @@
+package bucket
+
+import (
+	"context"
+	"sync"
+)
+
+// recordError is an error which applies to a single record in an object (for example an invalid line in a
+// line-delimited GeoJSON file) rather than the object as a whole.
+type recordError struct {
+	// path is the path of the record which triggered the error.
+	path string
+	// err is the underlying error.
+	err error
+}
+
+// newRecordError returns a new `recordError` wrapping 'err' for the record 'path'.
+func newRecordError(path string, err error) error {
+
+	e := &recordError{
+		path: path,
+		err:  err,
+	}
+
+	return e
+}
+
+// Error returns the string value of the underlying error.
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// recordErrorsKey is the context key used to store the `recordErrors` instance for a call to `BucketIterator.Iterate`.
+type recordErrorsKey struct{}
+
+// recordErrors is a queue of record errors waiting to be yielded to the caller of `BucketIterator.Iterate`.
+//
+// The concurrent iterator (from the whosonfirst/go-whosonfirst-iterate/v3 package) which wraps the bucket iterator stops
+// iterating a URI as soon as an error is yielded so record errors are passed around it, rather than through it, in
+// order that the remaining records in an object are still processed.
+type recordErrors struct {
+	mu     *sync.Mutex
+	errors []error
+}
+
+// newRecordErrors returns a new (empty) `recordErrors` instance.
+func newRecordErrors() *recordErrors {
+
+	q := &recordErrors{
+		mu:     new(sync.Mutex),
+		errors: make([]error, 0),
+	}
+
+	return q
+}
+
+// withRecordErrors returns a copy of 'ctx' carrying 'q'.
+func withRecordErrors(ctx context.Context, q *recordErrors) context.Context {
+	return context.WithValue(ctx, recordErrorsKey{}, q)
+}
+
+// recordErrorsFromContext returns the `recordErrors` instance carried by 'ctx' and a boolean value indicating whether it was found.
+func recordErrorsFromContext(ctx context.Context) (*recordErrors, bool) {
+	q, ok := ctx.Value(recordErrorsKey{}).(*recordErrors)
+	return q, ok
+}
+
+// Add appends 'err' to the queue.
+func (q *recordErrors) Add(err error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.errors = append(q.errors, err)
+}
+
+// Drain removes, and returns, all the errors in the queue.
+func (q *recordErrors) Drain() []error {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	errors := q.errors
+	q.errors = make([]error, 0)
+
+	return errors
+}
@@ -0,0 +1,89 @@
+package bucket
+
+import (
+	"sync"
+	"time"
+)
+
+// DEFAULT_RESUME_MAX_ATTEMPTS is the default maximum number of attempts to iterate a URI when resuming is enabled.
+const DEFAULT_RESUME_MAX_ATTEMPTS int = 3
+
+// DEFAULT_RESUME_DELAY is the default amount of time to wait before resuming a URI after a failed attempt.
+const DEFAULT_RESUME_DELAY time.Duration = 10 * time.Second
+
+// resumeState tracks the progress of iterating a single URI so that a subsequent attempt can resume after the
+// last object that was processed in full rather than starting from the beginning. It is local to a single call
+// to `Iterate` and is never shared between calls. It is safe for concurrent use.
+type resumeState struct {
+	// mu guards all the fields below.
+	mu *sync.Mutex
+	// ordered is a boolean flag indicating whether objects are processed in lexicographic order of their keys.
+	ordered bool
+	// last_key is the key of the last object that was processed in full, when 'ordered' is true.
+	last_key string
+	// completed is a lookup table of keys that were processed in full, when 'ordered' is false (for example `SOURCE_META`).
+	completed map[string]bool
+	// partial_key is the key of the object that was being processed when an attempt failed.
+	partial_key string
+	// partial_count is the number of records yielded for 'partial_key' before the attempt failed.
+	partial_count int64
+}
+
+// newResumeState returns a new (empty) `resumeState` instance. If 'ordered' is true objects are assumed to be
+// processed in lexicographic order of their keys.
+func newResumeState(ordered bool) *resumeState {
+
+	s := &resumeState{
+		mu:        new(sync.Mutex),
+		ordered:   ordered,
+		completed: make(map[string]bool),
+	}
+
+	return s
+}
+
+// Skip returns a boolean value indicating whether 'key' was processed in full during a previous attempt.
+func (s *resumeState) Skip(key string) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ordered {
+		return s.last_key != "" && key <= s.last_key
+	}
+
+	return s.completed[key]
+}
+
+// Yielded returns a boolean value indicating whether the record at (1-based) position 'n' of the records derived
+// from 'key' was yielded during a previous attempt. If not it is recorded as being yielded by the current attempt.
+func (s *resumeState) Yielded(key string, n int64) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == s.partial_key && n <= s.partial_count {
+		return true
+	}
+
+	s.partial_key = key
+	s.partial_count = n
+
+	return false
+}
+
+// Complete records that 'key' was processed in full.
+func (s *resumeState) Complete(key string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ordered {
+		s.last_key = key
+	} else {
+		s.completed[key] = true
+	}
+
+	s.partial_key = ""
+	s.partial_count = 0
+}
@@ -0,0 +1,368 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// newResumeFixtures copies the "compressed" fixtures to a temporary directory, adding a corrupt gzip-compressed
+// object whose key sorts between them, and returns the path to the directory and the path to the corrupt object.
+func newResumeFixtures(t *testing.T) (string, string) {
+
+	data_root := t.TempDir()
+
+	abs_path, err := filepath.Abs("fixtures/compressed")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	entries, err := os.ReadDir(abs_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read fixtures, %v", err)
+	}
+
+	for _, e := range entries {
+
+		body, err := os.ReadFile(filepath.Join(abs_path, e.Name()))
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", e.Name(), err)
+		}
+
+		err = os.WriteFile(filepath.Join(data_root, e.Name()), body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", e.Name(), err)
+		}
+	}
+
+	corrupt_path := filepath.Join(data_root, "1360391344.geojson.gz")
+
+	err = os.WriteFile(corrupt_path, []byte("not gzip"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write corrupt fixture, %v", err)
+	}
+
+	return data_root, corrupt_path
+}
+
+func TestBucketIteratorResume(t *testing.T) {
+
+	ctx := context.Background()
+
+	data_root, corrupt_path := newResumeFixtures(t)
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?decompress=true&_retry=true&_max_retries=2&_retry_after=1", data_root)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	bucket_it := it.(*BucketIterator)
+
+	resumed := 0
+
+	bucket_it.SetHooks(&Hooks{
+		OnError: func(ctx context.Context, key string, err error) {
+
+			// Repair the corrupt object so that the next attempt succeeds
+
+			body, read_err := os.ReadFile(filepath.Join(data_root, "1360391343.geojson.gz"))
+
+			if read_err != nil {
+				t.Errorf("Failed to read fixture, %v", read_err)
+				return
+			}
+
+			write_err := os.WriteFile(corrupt_path, body, 0644)
+
+			if write_err != nil {
+				t.Errorf("Failed to repair corrupt fixture, %v", write_err)
+			}
+		},
+		OnSkip: func(ctx context.Context, key string, reason string) {
+
+			if reason == SKIP_RESUMED {
+				resumed += 1
+			}
+		},
+	})
+
+	seen := make(map[string]int)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+
+		if rec.Path == "" {
+			t.Fatalf("Unexpected record with an empty path")
+		}
+
+		seen[rec.Path] += 1
+	}
+
+	if len(seen) != 6 {
+		t.Fatalf("Expected 6 records, but counted %d", len(seen))
+	}
+
+	for path, count := range seen {
+
+		if count != 1 {
+			t.Fatalf("Expected %s to be yielded once, but it was yielded %d times", path, count)
+		}
+	}
+
+	// The two objects before the corrupt one were processed in full during the first attempt
+
+	if resumed != 2 {
+		t.Fatalf("Expected 2 objects to be skipped when resuming, but counted %d", resumed)
+	}
+
+	// Each object is opened once, except the corrupt one which is opened once per attempt
+
+	opened := bucket_it.Stats().ObjectsOpened
+
+	if opened != 7 {
+		t.Fatalf("Expected 7 objects to be opened, but counted %d", opened)
+	}
+}
+
+func TestBucketIteratorResumeExhausted(t *testing.T) {
+
+	ctx := context.Background()
+
+	data_root, _ := newResumeFixtures(t)
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?decompress=true&_retry=true&_max_retries=1", data_root)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	iterate_once := func() ([]string, int) {
+
+		paths := make([]string, 0)
+		errors := 0
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				errors += 1
+				continue
+			}
+
+			rec.Body.Close()
+
+			if rec.Path == "" {
+				t.Fatalf("Unexpected record with an empty path")
+			}
+
+			paths = append(paths, rec.Path)
+		}
+
+		return paths, errors
+	}
+
+	first, first_errors := iterate_once()
+
+	if first_errors != 1 {
+		t.Fatalf("Expected 1 error, but counted %d", first_errors)
+	}
+
+	// The first call did not complete but that must not affect the next one
+
+	second, second_errors := iterate_once()
+
+	if second_errors != 1 {
+		t.Fatalf("Expected 1 error, but counted %d", second_errors)
+	}
+
+	if len(first) != len(second) {
+		t.Fatalf("Expected %d records, but counted %d", len(first), len(second))
+	}
+
+	for i, path := range first {
+
+		if second[i] != path {
+			t.Fatalf("Expected record %d to be %s, but got %s", i, path, second[i])
+		}
+	}
+}
+
+func TestBucketIteratorResumeDefaults(t *testing.T) {
+
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"a.geojson": `{"type": "Feature"}`,
+		"b.geojson": `{"type": "Feature"}`,
+		"c.geojson": `{"type": "Feature"}`,
+	}
+
+	fb := newFakeBucket(objects)
+
+	// Non-retryable errors are not retried by the requests themselves so this fails the first attempt
+
+	fb.get_errors = []error{nil, &fakeError{code: gcerrors.PermissionDenied}}
+
+	// Enable resuming without setting the maximum number of attempts
+
+	q, err := url.ParseQuery("resume=true")
+
+	if err != nil {
+		t.Fatalf("Failed to parse query, %v", err)
+	}
+
+	opts, err := NewBucketIteratorOptionsFromQuery(ctx, q)
+
+	if err != nil {
+		t.Fatalf("Failed to derive options, %v", err)
+	}
+
+	opts.ResumeDelay = 10 * time.Millisecond
+
+	it, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	paths := make([]string, 0)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Expected the failed attempt to be resumed, %v", err)
+		}
+
+		rec.Body.Close()
+		paths = append(paths, rec.Path)
+	}
+
+	if len(paths) != 3 || paths[0] != "a.geojson" || paths[1] != "b.geojson" || paths[2] != "c.geojson" {
+		t.Fatalf("Unexpected records, %v", paths)
+	}
+}
+
+func TestBucketIteratorResumeAfterStop(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?resume=true", abs_path)
+
+	it, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	// Stop part way through
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+
+		if count == 5 {
+			break
+		}
+	}
+
+	// Every call to Iterate starts from the beginning
+
+	count = 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+
+		if rec.Path == "" {
+			t.Fatalf("Unexpected record with an empty path")
+		}
+
+		count += 1
+	}
+
+	if count != 37 {
+		t.Fatalf("Expected 37 records, but counted %d", count)
+	}
+}
+
+// Ensure the resumeState type discards records yielded during a previous attempt.
+func TestResumeStateYielded(t *testing.T) {
+
+	s := newResumeState(true)
+
+	for n := int64(1); n <= 3; n++ {
+
+		if s.Yielded("a.tar", n) {
+			t.Fatalf("Unexpected record %d", n)
+		}
+	}
+
+	// Retry "a.tar" from the start
+
+	for n := int64(1); n <= 3; n++ {
+
+		if !s.Yielded("a.tar", n) {
+			t.Fatalf("Expected record %d to have been yielded", n)
+		}
+	}
+
+	if s.Yielded("a.tar", 4) {
+		t.Fatalf("Unexpected record 4")
+	}
+
+	s.Complete("a.tar")
+
+	if !s.Skip("a.tar") {
+		t.Fatalf("Expected a.tar to be skipped")
+	}
+
+	if s.Skip("b.tar") {
+		t.Fatalf("Did not expect b.tar to be skipped")
+	}
+}
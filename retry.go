@@ -0,0 +1,266 @@
+package bucket
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// DEFAULT_MAX_RETRIES is the default number of times a failed bucket operation will be retried.
+const DEFAULT_MAX_RETRIES int = 3
+
+// DEFAULT_RETRY_INITIAL_DELAY is the default (maximum) delay before the first retry of a failed bucket operation.
+const DEFAULT_RETRY_INITIAL_DELAY time.Duration = 100 * time.Millisecond
+
+// DEFAULT_RETRY_MAX_DELAY is the default upper bound for the delay between retries of a failed bucket operation.
+const DEFAULT_RETRY_MAX_DELAY time.Duration = 10 * time.Second
+
+// LIST_PAGE_SIZE is the number of objects requested for each page of a bucket listing.
+const LIST_PAGE_SIZE int = 1000
+
+// retryPolicy defines how individual bucket operations (listing pages, opening objects) are retried when they fail with a transient error.
+type retryPolicy struct {
+	// max_retries is the maximum number of times an operation will be retried. If 0 operations are not retried.
+	max_retries int
+	// initial_delay is the (maximum) delay before the first retry.
+	initial_delay time.Duration
+	// max_delay is the upper bound for the delay between retries.
+	max_delay time.Duration
+	// logger is the `slog.Logger` instance used to log retries. If nil `slog.Default()` is used.
+	logger *slog.Logger
+}
+
+// isRetryable returns a boolean value indicating whether 'err' is a transient error worth retrying. Note that
+// `gocloud.dev/gcerrors` does not define an "Unavailable" code; drivers report those conditions as one of
+// the codes below (typically ResourceExhausted or Internal) or as Unknown which is not retried.
+func isRetryable(err error) bool {
+
+	switch gcerrors.Code(err) {
+	case gcerrors.ResourceExhausted, gcerrors.DeadlineExceeded, gcerrors.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before retry number 'attempt' (starting at 1) using exponential backoff with "full jitter".
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+
+	ceiling := p.initial_delay << (attempt - 1)
+
+	if ceiling <= 0 || ceiling > p.max_delay {
+		ceiling = p.max_delay
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return rand.N(ceiling) + 1
+}
+
+// withRetries invokes 'fn' retrying it, according to 'p', if it fails with a retryable error. Non-retryable errors, or
+// errors after the context has been cancelled, are returned immediately.
+func withRetries[T any](ctx context.Context, p *retryPolicy, op string, key string, fn func() (T, error)) (T, error) {
+
+	attempt := 0
+
+	for {
+
+		v, err := fn()
+
+		if err == nil || p == nil || attempt >= p.max_retries || !isRetryable(err) || ctx.Err() != nil {
+			return v, err
+		}
+
+		attempt += 1
+		delay := p.backoff(attempt)
+
+		logger := p.logger
+
+		if logger == nil {
+			logger = slog.Default()
+		}
+
+		logger.Debug("Retry bucket operation", "op", op, "key", key, "attempt", attempt, "max retries", p.max_retries, "delay", delay, "code", gcerrors.Code(err), "error", err)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return v, err
+		case <-timer.C:
+			// pass
+		}
+	}
+}
+
+// newReader opens 'key' for reading, retrying transient errors according to the iterator's retry policy. Each attempt
+// waits for the iterator's "get" rate limiter and adaptive concurrency limit, if present. Reads from the returned reader
+// are metered and throttled according to the iterator's bandwidth limit.
+func (it *BucketIterator) newReader(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+
+	ctx, span := it.startSpan(ctx, "bucket.get", attribute.String("bucket.key", key))
+
+	fn := func() (*blob.Reader, error) {
+
+		err := it.get_limiter.Wait(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		open_fn := func() (*blob.Reader, error) {
+			atomic.AddInt64(&it.stats.get_calls, 1)
+			defer it.metrics.GetLatency(ctx, time.Now())
+			return it.bucket.NewReader(ctx, key, nil)
+		}
+
+		return withConcurrency(ctx, it.get_concurrency, open_fn)
+	}
+
+	r, err := withRetries(ctx, it.retry, "get", key, fn)
+
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("bucket.size", r.Size()))
+	endSpan(span, nil)
+
+	return it.meteredReader(ctx, r), nil
+}
+
+// newRangeReader opens 'length' bytes of 'key' starting at 'offset' for reading, retrying transient errors according
+// to the iterator's retry policy. Each attempt waits for the iterator's "get" rate limiter and adaptive concurrency
+// limit, if present. Reads from the returned reader are metered and throttled according to the iterator's bandwidth limit.
+func (it *BucketIterator) newRangeReader(ctx context.Context, key string, offset int64, length int64) (io.ReadSeekCloser, error) {
+
+	ctx, span := it.startSpan(ctx, "bucket.get_range",
+		attribute.String("bucket.key", key),
+		attribute.Int64("bucket.offset", offset),
+		attribute.Int64("bucket.length", length),
+	)
+
+	fn := func() (*blob.Reader, error) {
+
+		err := it.get_limiter.Wait(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		open_fn := func() (*blob.Reader, error) {
+			atomic.AddInt64(&it.stats.get_calls, 1)
+			defer it.metrics.GetLatency(ctx, time.Now())
+			return it.bucket.NewRangeReader(ctx, key, offset, length, nil)
+		}
+
+		return withConcurrency(ctx, it.get_concurrency, open_fn)
+	}
+
+	r, err := withRetries(ctx, it.retry, "get", key, fn)
+
+	endSpan(span, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return it.meteredReader(ctx, r), nil
+}
+
+// attributes returns the attributes for 'key', retrying transient errors according to the iterator's retry policy.
+// Each attempt waits for the iterator's "get" rate limiter and adaptive concurrency limit, if present.
+func (it *BucketIterator) attributes(ctx context.Context, key string) (*blob.Attributes, error) {
+
+	ctx, span := it.startSpan(ctx, "bucket.head", attribute.String("bucket.key", key))
+
+	fn := func() (*blob.Attributes, error) {
+
+		err := it.get_limiter.Wait(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attrs_fn := func() (*blob.Attributes, error) {
+			atomic.AddInt64(&it.stats.head_calls, 1)
+			defer it.metrics.GetLatency(ctx, time.Now())
+			return it.bucket.Attributes(ctx, key)
+		}
+
+		return withConcurrency(ctx, it.get_concurrency, attrs_fn)
+	}
+
+	attrs, err := withRetries(ctx, it.retry, "head", key, fn)
+
+	if err == nil {
+		span.SetAttributes(attribute.Int64("bucket.size", attrs.Size))
+	}
+
+	endSpan(span, err)
+	return attrs, err
+}
+
+// listPage is the result of a single call to `blob.Bucket.ListPage`.
+type listPage struct {
+	objects    []*blob.ListObject
+	next_token []byte
+}
+
+// listPage returns a single page of objects in the bucket matching 'opts', retrying transient errors according to the
+// iterator's retry policy. Each attempt waits for the iterator's "list" rate limiter and adaptive concurrency limit, if present.
+func (it *BucketIterator) listPage(ctx context.Context, token []byte, opts *blob.ListOptions) (*listPage, error) {
+
+	ctx, span := it.startSpan(ctx, "bucket.list_page", attribute.String("bucket.prefix", opts.Prefix))
+
+	fn := func() (*listPage, error) {
+
+		err := it.list_limiter.Wait(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list_fn := func() (*listPage, error) {
+
+			atomic.AddInt64(&it.stats.list_calls, 1)
+
+			start := time.Now()
+			objects, next_token, err := it.bucket.ListPage(ctx, token, LIST_PAGE_SIZE, opts)
+			it.metrics.ListLatency(ctx, start)
+
+			if err != nil {
+				return nil, err
+			}
+
+			page := &listPage{
+				objects:    objects,
+				next_token: next_token,
+			}
+
+			return page, nil
+		}
+
+		return withConcurrency(ctx, it.list_concurrency, list_fn)
+	}
+
+	page, err := withRetries(ctx, it.retry, "list", opts.Prefix, fn)
+
+	if err == nil {
+		span.SetAttributes(attribute.Int("bucket.objects", len(page.objects)))
+	}
+
+	endSpan(span, err)
+	return page, err
+}
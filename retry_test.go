@@ -0,0 +1,186 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestWithRetries(t *testing.T) {
+
+	ctx := context.Background()
+
+	p := &retryPolicy{
+		max_retries:   3,
+		initial_delay: 1 * time.Millisecond,
+		max_delay:     5 * time.Millisecond,
+	}
+
+	// Retryable errors are retried until the operation succeeds
+
+	calls := 0
+
+	v, err := withRetries(ctx, p, "test", "key", func() (int, error) {
+
+		calls += 1
+
+		if calls < 3 {
+			return 0, context.DeadlineExceeded
+		}
+
+		return calls, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected retryable operation to succeed, %v", err)
+	}
+
+	if v != 3 {
+		t.Fatalf("Expected 3 calls, but counted %d", v)
+	}
+
+	// Retryable errors are retried no more than max_retries times
+
+	calls = 0
+
+	_, err = withRetries(ctx, p, "test", "key", func() (int, error) {
+		calls += 1
+		return 0, context.DeadlineExceeded
+	})
+
+	if err == nil {
+		t.Fatalf("Expected operation to fail")
+	}
+
+	if calls != p.max_retries+1 {
+		t.Fatalf("Expected %d calls, but counted %d", p.max_retries+1, calls)
+	}
+
+	// Non-retryable errors fail immediately
+
+	fb := newFakeBucket(nil)
+	fb.get_errors = []error{&fakeError{code: gcerrors.PermissionDenied}}
+
+	b := blob.NewBucket(fb)
+	defer b.Close()
+
+	_, permission_err := b.NewReader(ctx, "key", nil)
+
+	if gcerrors.Code(permission_err) != gcerrors.PermissionDenied {
+		t.Fatalf("Expected a PermissionDenied error, but got %v", permission_err)
+	}
+
+	calls = 0
+
+	_, err = withRetries(ctx, p, "test", "key", func() (int, error) {
+		calls += 1
+		return 0, permission_err
+	})
+
+	if !errors.Is(err, permission_err) {
+		t.Fatalf("Expected operation to fail with a PermissionDenied error, but got %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("Expected 1 call, but counted %d", calls)
+	}
+}
+
+func TestBucketIteratorRetries(t *testing.T) {
+
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"a.geojson": `{"type": "Feature"}`,
+		"b.geojson": `{"type": "Feature"}`,
+	}
+
+	opts := DefaultBucketIteratorOptions()
+	opts.RetryInitialDelay = 1 * time.Millisecond
+
+	// Transient listing and read errors are retried
+
+	fb := newFakeBucket(objects)
+	fb.list_errors = []error{&fakeError{code: gcerrors.Internal}}
+	fb.get_errors = []error{&fakeError{code: gcerrors.ResourceExhausted}, &fakeError{code: gcerrors.DeadlineExceeded}}
+
+	it, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != 2 {
+		t.Fatalf("Expected 2 records, but counted %d", count)
+	}
+
+	list_calls, get_calls := fb.Calls()
+
+	if list_calls != 2 || get_calls != 4 {
+		t.Fatalf("Expected 2 listing and 4 read requests, but counted %d and %d", list_calls, get_calls)
+	}
+
+	st := it.(*BucketIterator).Stats()
+
+	if st.ListCalls != 2 || st.GetCalls != 4 {
+		t.Fatalf("Expected stats to report 2 listing and 4 read requests, but got %d and %d", st.ListCalls, st.GetCalls)
+	}
+
+	// Non-retryable errors are not
+
+	fb = newFakeBucket(objects)
+	fb.get_errors = []error{&fakeError{code: gcerrors.PermissionDenied}}
+
+	it, err = NewBucketIteratorWithBucket(ctx, blob.NewBucket(fb), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	errs := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+
+			if gcerrors.Code(err) != gcerrors.PermissionDenied {
+				t.Fatalf("Expected a PermissionDenied error, but got %v", err)
+			}
+
+			errs += 1
+			continue
+		}
+
+		rec.Body.Close()
+	}
+
+	if errs != 1 {
+		t.Fatalf("Expected 1 error, but counted %d", errs)
+	}
+
+	_, get_calls = fb.Calls()
+
+	if get_calls != 1 {
+		t.Fatalf("Expected 1 read request, but counted %d", get_calls)
+	}
+}
@@ -0,0 +1,59 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+)
+
+// CATCHALL_SCHEME is a single scheme, registered when this package is initialized, which can be used to iterate any bucket
+// whose `gocloud.dev/blob` driver is registered with `blob.DefaultURLMux()` by the time the iterator is created, regardless
+// of import order. URIs take the form of:
+//
+//	bucket://{SCHEME}/{REMAINDER}?{PARAMETERS}
+//
+// Where {SCHEME} is a registered `gocloud.dev/blob` driver and {REMAINDER} is everything in the `gocloud.dev/blob` URI after
+// "{SCHEME}://". For example "bucket://s3/my-bucket?region=us-east-1" is equivalent to "bucket-s3://my-bucket?region=us-east-1"
+// and "bucket://file//usr/local/data" is equivalent to "bucket-file:///usr/local/data".
+const CATCHALL_SCHEME string = "bucket"
+
+// NewIterator returns a new `iterate.Iterator` instance derived from 'uri', using `iterate.NewIterator`, after first
+// registering any `gocloud.dev/blob` schemes (with the "bucket-" prefix) that were not registered when this package was
+// initialized. This removes the need to call `RegisterSchemes` when drivers are imported after this package.
+func NewIterator(ctx context.Context, uri string) (iterate.Iterator, error) {
+
+	err := RegisterSchemes(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to register schemes, %w", err)
+	}
+
+	return iterate.NewIterator(ctx, uri)
+}
+
+// resolveCatchAllURI returns the "bucket-{SCHEME}://" equivalent of 'u' which is a `CATCHALL_SCHEME` URI.
+func resolveCatchAllURI(u *url.URL) (*url.URL, error) {
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Catch-all URI is missing a scheme, expected %s://{SCHEME}/{REMAINDER}", CATCHALL_SCHEME)
+	}
+
+	rest := strings.TrimPrefix(u.EscapedPath(), "/")
+
+	if u.RawQuery != "" {
+		rest = rest + "?" + u.RawQuery
+	}
+
+	resolved_uri := fmt.Sprintf("%s%s://%s", PREFIX, u.Host, rest)
+
+	resolved_u, err := url.Parse(resolved_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse resolved URI '%s', %w", scrubURI(resolved_uri), scrubError(err, resolved_uri))
+	}
+
+	return resolved_u, nil
+}
@@ -0,0 +1,126 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v3"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+)
+
+func TestNewIteratorLateScheme(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// Simulate a driver which is registered after this package has been initialized
+
+	blob.DefaultURLMux().RegisterBucket("latefile", &fileblob.URLOpener{})
+
+	iter_uri := fmt.Sprintf("bucket-latefile://%s", abs_path)
+
+	it, err := NewIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator for late scheme, %v", err)
+	}
+
+	defer it.Close()
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count != 37 {
+		t.Fatalf("Unexpected count: %d", count)
+	}
+}
+
+func TestCatchAllScheme(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	blob.DefaultURLMux().RegisterBucket("catchallfile", &fileblob.URLOpener{})
+
+	iter_uri := fmt.Sprintf("bucket://catchallfile/%s?include=properties.mz:is_current=1", abs_path)
+
+	it, err := iterate.NewIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create catch-all iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	count := 0
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	if count == 0 || count == 37 {
+		t.Fatalf("Unexpected count: %d", count)
+	}
+
+	_, err = iterate.NewIterator(ctx, "bucket:///tmp")
+
+	if err == nil {
+		t.Fatalf("Expected catch-all URI without a scheme to fail")
+	}
+}
+
+func TestResolveCatchAllURI(t *testing.T) {
+
+	tests := map[string]string{
+		"bucket://s3/my-bucket?region=us-east-1&include=a=b": "bucket-s3://my-bucket?region=us-east-1&include=a=b",
+		"bucket://file//usr/local/data":                      "bucket-file:///usr/local/data",
+	}
+
+	for uri, expected := range tests {
+
+		u, err := url.Parse(uri)
+
+		if err != nil {
+			t.Fatalf("Failed to parse '%s', %v", uri, err)
+		}
+
+		u, err = resolveCatchAllURI(u)
+
+		if err != nil {
+			t.Fatalf("Failed to resolve '%s', %v", uri, err)
+		}
+
+		if u.String() != expected {
+			t.Fatalf("Unexpected resolution for '%s': %s", uri, u.String())
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package bucket
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// SCRUBBED_VALUE is the value which replaces sensitive data in scrubbed URIs and error messages.
+const SCRUBBED_VALUE string = "..."
+
+// MIN_SCRUBBED_VALUE_LENGTH is the minimum length of a sensitive parameter value for it to be scrubbed wherever it
+// appears in an error message, rather than only as part of the URI it was derived from. Shorter values (for example
+// "awssdk=v2") are too likely to match unrelated text.
+const MIN_SCRUBBED_VALUE_LENGTH int = 8
+
+// scrub_params are the (lower-case) names of query parameters used by common `gocloud.dev/blob` drivers, and by signed
+// URLs, whose values are sensitive.
+var scrub_params = []string{
+	// Generic
+	"access_token",
+	"token",
+	"password",
+	"secret",
+	"api_key",
+	"apikey",
+	// Azure shared access signature (SAS) tokens
+	"sig",
+	"sas",
+	"sas_token",
+	// AWS credentials, profile hints and presigned URLs
+	"awssdk",
+	"profile",
+	"aws_access_key_id",
+	"aws_secret_access_key",
+	"aws_session_token",
+	"x-amz-credential",
+	"x-amz-signature",
+	"x-amz-security-token",
+	// Google Cloud Storage credentials and signed URLs
+	"access_id",
+	"private_key_path",
+	"googleaccessid",
+	"signature",
+	"x-goog-credential",
+	"x-goog-signature",
+	// fileblob signed URLs
+	"secret_key_path",
+}
+
+// scrub_fragments are fragments of query parameter names which, if present, cause the parameter's value to be scrubbed.
+var scrub_fragments = []string{
+	"secret",
+	"token",
+	"password",
+	"signature",
+	"credential",
+}
+
+// uri_params are the bucket iterator parameters whose values are themselves `gocloud.dev/blob` URIs and are scrubbed in turn.
+var uri_params = []string{
+	"dead_letter",
+	"listing_cache",
+}
+
+// ScrubURI returns a copy of 'uri' which is safe to include in log messages and errors. Unlike `iterate.ScrubURI`,
+// which only masks "access_token" parameters, it replaces the user information (for example basic-auth credentials)
+// and the values of query parameters known to carry secrets in common `gocloud.dev/blob` drivers and signed URLs
+// (for example Azure SAS tokens, AWS "awssdk" and "profile" hints and "X-Amz-Signature" or "X-Goog-Signature" parameters)
+// with `SCRUBBED_VALUE`. The values of the "dead_letter" and "listing_cache" parameters are scrubbed in turn.
+func ScrubURI(uri string) (string, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return "", scrubError(err, uri)
+	}
+
+	if u.User != nil {
+		u.User = url.User(SCRUBBED_VALUE)
+	}
+
+	if u.RawQuery != "" {
+
+		q := u.Query()
+
+		for k, values := range q {
+
+			if isSensitiveParameter(k) {
+
+				for i := range values {
+					values[i] = SCRUBBED_VALUE
+				}
+
+				continue
+			}
+
+			if slices.Contains(uri_params, k) {
+
+				for i, v := range values {
+					values[i] = scrubURI(v)
+				}
+			}
+		}
+
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// scrubURI returns the result of `ScrubURI` for 'uri' or, if it can not be parsed, `SCRUBBED_VALUE`.
+func scrubURI(uri string) string {
+
+	scrubbed, err := ScrubURI(uri)
+
+	if err != nil {
+		return SCRUBBED_VALUE
+	}
+
+	return scrubbed
+}
+
+// isSensitiveParameter returns a boolean value indicating whether the query parameter 'k' carries a secret.
+func isSensitiveParameter(k string) bool {
+
+	k = strings.ToLower(k)
+
+	if slices.Contains(scrub_params, k) {
+		return true
+	}
+
+	for _, f := range scrub_fragments {
+
+		if strings.Contains(k, f) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sensitiveValues returns the user information password and the values of the sensitive query parameters in 'uri' (and
+// in the URIs it contains) which are at least `MIN_SCRUBBED_VALUE_LENGTH` characters long.
+func sensitiveValues(uri string) []string {
+
+	values := make([]string, 0)
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return values
+	}
+
+	if u.User != nil {
+
+		pswd, ok := u.User.Password()
+
+		if ok {
+			values = append(values, pswd)
+		}
+
+		values = append(values, u.User.Username())
+	}
+
+	for k, v := range u.Query() {
+
+		switch {
+		case isSensitiveParameter(k):
+			values = append(values, v...)
+		case slices.Contains(uri_params, k):
+			for _, nested := range v {
+				values = append(values, sensitiveValues(nested)...)
+			}
+		}
+	}
+
+	long := make([]string, 0)
+
+	for _, v := range values {
+
+		if len(v) >= MIN_SCRUBBED_VALUE_LENGTH {
+			long = append(long, v, url.QueryEscape(v))
+		}
+	}
+
+	return long
+}
+
+// scrubbedError is an error whose message has been scrubbed of sensitive data but which still wraps the original error.
+type scrubbedError struct {
+	// message is the scrubbed error message.
+	message string
+	// err is the original error.
+	err error
+}
+
+// Error returns the scrubbed error message.
+func (e *scrubbedError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the original error.
+func (e *scrubbedError) Unwrap() error {
+	return e.err
+}
+
+// scrubError returns a copy of 'err' whose message has each of 'uris', and any sensitive values they contain, replaced by
+// their scrubbed equivalents. The returned error wraps 'err' so `errors.Is`, `errors.As` and `gocloud.dev/gcerrors.Code`
+// behave as they would for 'err'. If there is nothing to scrub 'err' is returned as is.
+func scrubError(err error, uris ...string) error {
+
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+
+	for _, uri := range uris {
+
+		if uri == "" {
+			continue
+		}
+
+		forms := []string{uri}
+
+		u, parse_err := url.Parse(uri)
+
+		if parse_err == nil {
+			forms = append(forms, u.String())
+		}
+
+		scrubbed := SCRUBBED_VALUE
+
+		if parse_err == nil {
+			scrubbed = scrubURI(uri)
+		}
+
+		for _, f := range forms {
+			msg = strings.ReplaceAll(msg, f, scrubbed)
+		}
+
+		for _, v := range sensitiveValues(uri) {
+			msg = strings.ReplaceAll(msg, v, SCRUBBED_VALUE)
+		}
+	}
+
+	if msg == err.Error() {
+		return err
+	}
+
+	return &scrubbedError{
+		message: msg,
+		err:     err,
+	}
+}
@@ -0,0 +1,222 @@
+package bucket
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocloud.dev/gcerrors"
+)
+
+// DEFAULT_STATS_INTERVAL is the default interval at which bucket iterator stats are logged.
+const DEFAULT_STATS_INTERVAL time.Duration = 1 * time.Minute
+
+// Stats is a snapshot of the work performed by a `BucketIterator`.
+type Stats struct {
+	// KeysListed is the number of keys derived from the iterator's source.
+	KeysListed int64 `json:"keys_listed"`
+	// KeysProcessed is the number of keys whose objects were processed in full, whether or not any records were
+	// yielded for them, or which were skipped. Keys skipped because they were processed during a previous attempt
+	// to iterate a URI are not counted again.
+	KeysProcessed int64 `json:"keys_processed"`
+	// ObjectsOpened is the number of objects opened for reading (including objects read from the local object cache).
+	ObjectsOpened int64 `json:"objects_opened"`
+	// BytesRead is the number of bytes read from the bucket.
+	BytesRead int64 `json:"bytes_read"`
+	// BytesPerSecond is the current combined throughput of all the object bodies being read from the bucket.
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// RecordsYielded is the number of records yielded to the caller.
+	RecordsYielded int64 `json:"records_yielded"`
+	// RecordsFiltered is the number of records excluded by the iterator's filters, keyed by the filter ("include", "exclude",
+	// "filters" or, for records excluded by the concurrent iterator which wraps the bucket iterator, "iterator") which excluded them.
+	RecordsFiltered map[string]int64 `json:"records_filtered"`
+	// Errors is the number of errors encountered keyed by their `gocloud.dev/gcerrors` code.
+	Errors map[string]int64 `json:"errors"`
+	// ListCalls is the number of listing requests made to the bucket, including retries.
+	ListCalls int64 `json:"list_calls"`
+	// GetCalls is the number of object read (including ranged reads) requests made to the bucket, including retries.
+	GetCalls int64 `json:"get_calls"`
+	// HeadCalls is the number of attribute requests made to the bucket, including retries.
+	HeadCalls int64 `json:"head_calls"`
+	// CacheHits is the number of objects read from the local object cache.
+	CacheHits int64 `json:"cache_hits"`
+	// CacheMisses is the number of objects which were not in the local object cache and were read from the bucket (and added to the cache).
+	CacheMisses int64 `json:"cache_misses"`
+	// Elapsed is the time since iteration started. If the iterator is not iterating it is the time between the start of the
+	// first call to `Iterate` and the end of the last one.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// iteratorStats accumulates the counters reported by `Stats`. It is safe for concurrent use.
+type iteratorStats struct {
+	keys_listed    int64
+	keys_processed int64
+	objects_opened int64
+	list_calls     int64
+	get_calls      int64
+	head_calls     int64
+	cache_hits     int64
+	cache_misses   int64
+	// mu guards all the fields below.
+	mu       *sync.Mutex
+	filtered map[string]int64
+	errors   map[string]int64
+	// active is the number of calls to `Iterate` in progress.
+	active int
+	// started is the time the first call to `Iterate` started.
+	started time.Time
+	// finished is the time the last call to `Iterate` finished.
+	finished time.Time
+}
+
+// newIteratorStats returns a new `iteratorStats` instance.
+func newIteratorStats() *iteratorStats {
+
+	s := &iteratorStats{
+		mu:       new(sync.Mutex),
+		filtered: make(map[string]int64),
+		errors:   make(map[string]int64),
+	}
+
+	return s
+}
+
+// Start records that a call to `Iterate` started.
+func (s *iteratorStats) Start() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+
+	s.active += 1
+}
+
+// Finish records that a call to `Iterate` finished.
+func (s *iteratorStats) Finish() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active -= 1
+	s.finished = time.Now()
+}
+
+// Filtered records that a record was excluded by 'filter'.
+func (s *iteratorStats) Filtered(filter string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filtered[filter] += 1
+}
+
+// Error records 'err' by its `gocloud.dev/gcerrors` code.
+func (s *iteratorStats) Error(err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[gcerrors.Code(err).String()] += 1
+}
+
+// Stats returns a snapshot of the iterator's stats. It is safe to call while iteration is in progress.
+func (it *BucketIterator) Stats() *Stats {
+
+	s := it.stats
+
+	st := &Stats{
+		KeysListed:     atomic.LoadInt64(&s.keys_listed),
+		KeysProcessed:  atomic.LoadInt64(&s.keys_processed),
+		ObjectsOpened:  atomic.LoadInt64(&s.objects_opened),
+		BytesRead:      it.BytesRead(),
+		BytesPerSecond: it.Throughput(),
+		RecordsYielded: it.Seen(),
+		ListCalls:      atomic.LoadInt64(&s.list_calls),
+		GetCalls:       atomic.LoadInt64(&s.get_calls),
+		HeadCalls:      atomic.LoadInt64(&s.head_calls),
+		CacheHits:      atomic.LoadInt64(&s.cache_hits),
+		CacheMisses:    atomic.LoadInt64(&s.cache_misses),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.RecordsFiltered = maps.Clone(s.filtered)
+	st.Errors = maps.Clone(s.errors)
+
+	switch {
+	case s.started.IsZero():
+		// pass
+	case s.active > 0:
+		st.Elapsed = time.Since(s.started)
+	default:
+		st.Elapsed = s.finished.Sub(s.started)
+	}
+
+	return st
+}
+
+// logStats logs the iterator's current stats at the iterator's stats level.
+func (it *BucketIterator) logStats(ctx context.Context) {
+
+	st := it.Stats()
+
+	it.logger.Log(ctx, it.stats_level,
+		"Bucket iterator stats",
+		"seen", st.RecordsYielded,
+		"keys listed", st.KeysListed,
+		"keys processed", st.KeysProcessed,
+		"objects opened", st.ObjectsOpened,
+		"bytes read", st.BytesRead,
+		"bytes per second", int64(st.BytesPerSecond),
+		"filtered", st.RecordsFiltered,
+		"errors", st.Errors,
+		"list calls", st.ListCalls,
+		"get calls", st.GetCalls,
+		"head calls", st.HeadCalls,
+		"cache hits", st.CacheHits,
+		"cache misses", st.CacheMisses,
+		"elapsed", st.Elapsed,
+	)
+}
+
+// startStats starts logging the iterator's stats every 'stats_interval' and returns a function which stops logging
+// them, after logging them one last time. It is called once for each call to `Iterate` so no goroutines outlive
+// iteration. If stats logging is disabled the returned function is a no-op.
+func (it *BucketIterator) startStats(ctx context.Context) func() {
+
+	if !it.with_stats {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(it.stats_interval)
+
+	done_ch := make(chan bool)
+	stopped_ch := make(chan bool)
+
+	go func() {
+
+		defer close(stopped_ch)
+
+		for {
+			select {
+			case <-done_ch:
+				it.logStats(ctx)
+				return
+			case <-ticker.C:
+				it.logStats(ctx)
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done_ch)
+		<-stopped_ch
+	}
+}
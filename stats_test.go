@@ -0,0 +1,182 @@
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBucketIteratorStats(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	// Records excluded by the concurrent iterator which wraps the bucket iterator are not counted as yielded
+
+	iter_uri := fmt.Sprintf("bucket-file://%s?include=properties.mz:is_current=1&_exclude=%s", abs_path, url.QueryEscape("^174/"))
+
+	iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	it := iter.(*BucketIterator)
+
+	st := it.Stats()
+
+	if st.KeysListed != 0 || st.Elapsed != 0 {
+		t.Fatalf("Expected empty stats before iterating, %v", st)
+	}
+
+	// Stats must be safe to call while iteration is in progress
+
+	done := make(chan bool)
+	wg := new(sync.WaitGroup)
+
+	wg.Add(1)
+
+	go func() {
+
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				it.Stats()
+			}
+		}
+	}()
+
+	count := int64(0)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+		count += 1
+	}
+
+	close(done)
+	wg.Wait()
+
+	st = it.Stats()
+
+	if st.KeysListed != 37 {
+		t.Fatalf("Expected 37 keys listed, but got %d", st.KeysListed)
+	}
+
+	if st.ObjectsOpened != 37 {
+		t.Fatalf("Expected 37 objects opened, but got %d", st.ObjectsOpened)
+	}
+
+	if st.GetCalls != 37 {
+		t.Fatalf("Expected 37 get calls, but got %d", st.GetCalls)
+	}
+
+	if st.ListCalls == 0 {
+		t.Fatalf("Expected list calls")
+	}
+
+	if st.RecordsYielded != count {
+		t.Fatalf("Expected %d records yielded, but got %d", count, st.RecordsYielded)
+	}
+
+	// The 3 records whose paths start with "174/" match the include rules but are excluded by the '_exclude' parameter
+
+	if st.RecordsFiltered[FILTER_INCLUDE] != 37-count-3 {
+		t.Fatalf("Expected %d records filtered by include rules, but got %d", 37-count-3, st.RecordsFiltered[FILTER_INCLUDE])
+	}
+
+	if st.BytesRead == 0 {
+		t.Fatalf("Expected bytes read")
+	}
+
+	if len(st.Errors) != 0 {
+		t.Fatalf("Unexpected errors, %v", st.Errors)
+	}
+
+	if st.Elapsed <= 0 {
+		t.Fatalf("Expected elapsed time")
+	}
+
+	if it.Stats().Elapsed != st.Elapsed {
+		t.Fatalf("Expected elapsed time to stop when iteration is complete")
+	}
+}
+
+func TestBucketIteratorStatsLogging(t *testing.T) {
+
+	ctx := context.Background()
+
+	if DefaultBucketIteratorOptions().WithStats {
+		t.Fatalf("Expected bucket stats logging to be disabled by default")
+	}
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	for _, with_stats := range []bool{false, true} {
+
+		iter_uri := fmt.Sprintf("bucket-file://%s", abs_path)
+
+		if with_stats {
+			iter_uri = fmt.Sprintf("%s?_with_stats=true", iter_uri)
+		}
+
+		iter, err := NewBucketIterator(ctx, iter_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create bucket iterator, %v", err)
+		}
+
+		var buf bytes.Buffer
+
+		it := iter.(*BucketIterator)
+		it.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+		for rec, err := range it.Iterate(ctx, ".") {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate bucket, %v", err)
+			}
+
+			rec.Body.Close()
+		}
+
+		// Stats are logged one last time when iteration finishes and not after that
+
+		logged := strings.Count(buf.String(), "Bucket iterator stats")
+
+		if with_stats && logged != 1 {
+			t.Fatalf("Expected stats to be logged once, but counted %d", logged)
+		}
+
+		if !with_stats && logged != 0 {
+			t.Fatalf("Expected stats not to be logged, but counted %d", logged)
+		}
+
+		iter.Close()
+	}
+}
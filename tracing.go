@@ -0,0 +1,59 @@
+package bucket
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// SetTracerProvider replaces the `trace.TracerProvider` used to create the iterator's OpenTelemetry spans. By default
+// iterators use a no-op provider and record nothing. It should be called before iteration starts.
+//
+// Each call to `Iterate` creates a "bucket.iterate" span with a "bucket.uri" child span for each URI. Each URI span has child
+// spans for each page of listings and each object (covering the time spent opening and reading it and deriving its records)
+// and each object span has child spans for each request to open the object or fetch its attributes.
+// The contexts passed to the underlying `gocloud.dev/blob.Bucket` carry these spans so driver spans nest beneath them.
+func (it *BucketIterator) SetTracerProvider(tp trace.TracerProvider) {
+	it.tracer = tp.Tracer(INSTRUMENTATION_NAME)
+}
+
+// newNoopTracer returns a new `trace.Tracer` which records nothing.
+func newNoopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(INSTRUMENTATION_NAME)
+}
+
+// startSpan starts a new span called 'name', with 'attrs', as a child of any span in 'ctx'.
+func (it *BucketIterator) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return it.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// objectAttributes returns the span attributes for 'obj'.
+func objectAttributes(obj *blob.ListObject) []attribute.KeyValue {
+
+	attrs := []attribute.KeyValue{
+		attribute.String("bucket.key", obj.Key),
+	}
+
+	if obj.Size > 0 {
+		attrs = append(attrs, attribute.Int64("bucket.size", obj.Size))
+	}
+
+	return attrs
+}
+
+// endSpan records 'err', if not nil, on 'span' and then ends it.
+func endSpan(span trace.Span, err error) {
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("bucket.error_code", gcerrors.Code(err).String()))
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
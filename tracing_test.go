@@ -0,0 +1,183 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/blob"
+)
+
+func TestBucketIteratorTracing(t *testing.T) {
+
+	ctx := context.Background()
+
+	abs_path, err := filepath.Abs("fixtures/data")
+
+	if err != nil {
+		t.Fatalf("Failed to derive absolute path for fixtures, %v", err)
+	}
+
+	iter_uri := fmt.Sprintf("bucket-file://%s", abs_path)
+
+	iter, err := NewBucketIterator(ctx, iter_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create bucket iterator, %v", err)
+	}
+
+	defer iter.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	it := iter.(*BucketIterator)
+	it.SetTracerProvider(tp)
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	spans := exporter.GetSpans()
+	counts := make(map[string]int)
+
+	var parent tracetest.SpanStub
+
+	for _, s := range spans {
+
+		counts[s.Name] += 1
+
+		if s.Name == "bucket.iterate" {
+			parent = s
+		}
+	}
+
+	if counts["bucket.iterate"] != 1 {
+		t.Fatalf("Expected 1 iterate span, but got %d", counts["bucket.iterate"])
+	}
+
+	if counts["bucket.list_page"] == 0 {
+		t.Fatalf("Expected list page spans")
+	}
+
+	if counts["bucket.object"] != 37 {
+		t.Fatalf("Expected 37 object spans, but got %d", counts["bucket.object"])
+	}
+
+	if counts["bucket.get"] != 37 {
+		t.Fatalf("Expected 37 get spans, but got %d", counts["bucket.get"])
+	}
+
+	for _, s := range spans {
+
+		if s.Name == "bucket.iterate" {
+			continue
+		}
+
+		if s.SpanContext.TraceID() != parent.SpanContext.TraceID() {
+			t.Fatalf("Span %s is not part of the iterate trace", s.Name)
+		}
+	}
+
+	if counts["bucket.uri"] != 1 {
+		t.Fatalf("Expected 1 URI span, but got %d", counts["bucket.uri"])
+	}
+
+	// Ensure each span is a child of the expected span
+
+	names := make(map[trace.SpanID]string)
+
+	for _, s := range spans {
+		names[s.SpanContext.SpanID()] = s.Name
+	}
+
+	parents := map[string]string{
+		"bucket.uri":       "bucket.iterate",
+		"bucket.list_page": "bucket.uri",
+		"bucket.object":    "bucket.uri",
+		"bucket.get":       "bucket.object",
+	}
+
+	for _, s := range spans {
+
+		expected, ok := parents[s.Name]
+
+		if !ok {
+			continue
+		}
+
+		if names[s.Parent.SpanID()] != expected {
+			t.Fatalf("Expected parent of %s span to be %s, but got '%s'", s.Name, expected, names[s.Parent.SpanID()])
+		}
+	}
+
+	for _, s := range spans {
+
+		if s.Name != "bucket.uri" {
+			continue
+		}
+
+		for _, a := range s.Attributes {
+
+			if string(a.Key) == "bucket.uri" && a.Value.AsString() != "." {
+				t.Fatalf("Unexpected bucket.uri attribute, %s", a.Value.AsString())
+			}
+		}
+	}
+}
+
+func TestBucketIteratorTracerProviderOption(t *testing.T) {
+
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"a.geojson": `{"type": "Feature"}`,
+		"b.geojson": `{"type": "Feature"}`,
+		"c.geojson": `{"type": "Feature"}`,
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+
+	opts := DefaultBucketIteratorOptions()
+	opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	it, err := NewBucketIteratorWithBucket(ctx, blob.NewBucket(newFakeBucket(objects)), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	defer it.Close()
+
+	for rec, err := range it.Iterate(ctx, ".") {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate bucket, %v", err)
+		}
+
+		rec.Body.Close()
+	}
+
+	counts := make(map[string]int)
+
+	for _, s := range exporter.GetSpans() {
+		counts[s.Name] += 1
+	}
+
+	if counts["bucket.iterate"] != 1 {
+		t.Fatalf("Expected 1 iterate span, but got %d", counts["bucket.iterate"])
+	}
+
+	if counts["bucket.object"] != 3 {
+		t.Fatalf("Expected 3 object spans, but got %d", counts["bucket.object"])
+	}
+}